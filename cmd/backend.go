@@ -0,0 +1,522 @@
+// Copyright © 2023 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+// backend is a source or destination of state named by a spec, as taken by --from and --to
+type backend struct {
+	spec   string
+	pg     *sqlx.DB             // set for backends with an ipld.blocks table: Postgres and SQLite
+	reader ethdb.KeyValueReader // reads blocks by CID, e.g. as a repair source
+
+	// opens a validator over the backend; for destinations, this finishes writing first
+	validator func(validator.Params) (*validator.Validator, error)
+	// returns a writer keyed by CID, for destinations
+	writer func(blockNumber uint64) (ethdb.KeyValueWriter, error)
+}
+
+// Opens a backend from a spec:
+//
+//	postgres               the database configured by --config and the database flags
+//	postgres://...         a Postgres connection string
+//	ipfs:<repo path>       an IPFS repository
+//	ipfs-repo:<repo path>  an IPFS repository's blockstore, read directly without the repo lock; as a source only
+//	chaindata:<dir>        a geth LevelDB or Pebble database, with the freezer at <dir>/ancient if it exists
+//	sqlite:<file>          a SQLite database in the ipld.blocks layout; created if it is written to and does not exist
+//	car:<file>             a CAR file; written as a CARv2 file, which is resumed if it already exists
+//	dump:<file>            a COPY dump of ipld.blocks, ingested into an on-disk index; as a source only
+//	rpc:<url>              a geth-compatible JSON-RPC endpoint, read with debug_dbGet
+//	kubo:<url>             a kubo node's RPC API, read with /api/v0/block/get
+//	gateway:<url>          an IPFS trustless gateway, read with ?format=raw
+func openBackend(spec string, writable bool, stateRoot common.Hash) (*backend, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	b := &backend{spec: spec}
+	switch {
+	case spec == "postgres" || strings.HasPrefix(spec, "postgres://") || strings.HasPrefix(spec, "postgresql://"):
+		// connection strings take the pool settings of the database flags
+		var config validator.Config
+		validator.LoadViper(&config)
+		if spec != "postgres" {
+			config.URL = spec
+		}
+		var err error
+		if b.pg, err = validator.Connect(config); err != nil {
+			return nil, err
+		}
+		b.reader = validator.NewPGIPFSSource(b.pg)
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewPGIPFSValidator(b.pg, params), nil
+		}
+		b.writer = func(blockNumber uint64) (ethdb.KeyValueWriter, error) {
+			return validator.NewPGIPFSWriter(b.pg, blockNumber), nil
+		}
+	case kind == "sqlite":
+		var err error
+		if b.pg, err = validator.OpenSQLite(path, writable); err != nil {
+			return nil, err
+		}
+		b.reader = validator.NewSQLiteDatabase(b.pg)
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewSQLiteValidator(b.pg, params), nil
+		}
+		b.writer = func(blockNumber uint64) (ethdb.KeyValueWriter, error) {
+			database := validator.NewSQLiteDatabase(b.pg)
+			database.BlockNumber = blockNumber
+			return database, nil
+		}
+	case kind == "ipfs":
+		bs, err := validator.InitIPFSBlockService(path)
+		if err != nil {
+			return nil, err
+		}
+		b.reader = validator.NewBlockstoreDatabase(validator.BlockServiceStore{BlockService: bs})
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewIPFSValidator(bs, params), nil
+		}
+		b.writer = func(uint64) (ethdb.KeyValueWriter, error) {
+			return validator.NewBlockstoreDatabase(validator.BlockServiceStore{BlockService: bs}), nil
+		}
+	case kind == "ipfs-repo":
+		bs, err := validator.OpenIPFSRepo(path)
+		if err != nil {
+			return nil, err
+		}
+		b.reader = validator.NewBlockstoreDatabase(bs)
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewIPFSRepoValidator(bs, params), nil
+		}
+	case kind == "chaindata":
+		db, err := validator.OpenChaindata(validator.ChaindataConfig{
+			Directory: path,
+			Cache:     viper.GetInt("chaindata.cache"),
+			Handles:   viper.GetInt("chaindata.handles"),
+			Writable:  writable,
+		})
+		if err != nil {
+			return nil, err
+		}
+		b.reader = validator.NewChaindataDatabase(db)
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewChaindataValidator(db, params), nil
+		}
+		b.writer = func(uint64) (ethdb.KeyValueWriter, error) {
+			return validator.NewChaindataDatabase(db), nil
+		}
+	case kind == "car" && writable:
+		c, err := validator.StateRootCID(stateRoot)
+		if err != nil {
+			return nil, err
+		}
+		rw, err := carblockstore.OpenReadWrite(path, []cid.Cid{c})
+		if err != nil {
+			return nil, err
+		}
+		b.reader = validator.NewBlockstoreDatabase(rw)
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			if err := rw.Finalize(); err != nil {
+				return nil, err
+			}
+			car, err := validator.OpenCAR(path)
+			if err != nil {
+				return nil, err
+			}
+			return validator.NewCARValidator(car, params), nil
+		}
+		b.writer = func(uint64) (ethdb.KeyValueWriter, error) {
+			return validator.NewBlockstoreDatabase(rw), nil
+		}
+	case kind == "dump":
+		db, err := openDump(path)
+		if err != nil {
+			return nil, err
+		}
+		b.reader = db
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewDumpValidator(db, params), nil
+		}
+	case kind == "rpc":
+		db, err := validator.DialRPC(context.Background(), rpcConfig(path))
+		if err != nil {
+			return nil, err
+		}
+		b.reader = db
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewRPCValidator(db, params), nil
+		}
+	case kind == validator.KuboAPI || kind == validator.GatewayAPI:
+		bs, err := validator.NewHTTPBlockstore(httpBlockConfig(kind, path))
+		if err != nil {
+			return nil, err
+		}
+		b.reader = validator.NewBlockstoreDatabase(bs)
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewHTTPValidator(bs, params), nil
+		}
+	case kind == "car":
+		car, err := validator.OpenCAR(path)
+		if err != nil {
+			return nil, err
+		}
+		b.reader = validator.NewBlockstoreDatabase(car)
+		b.validator = func(params validator.Params) (*validator.Validator, error) {
+			return validator.NewCARValidator(car, params), nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid backend: '%s'", spec)
+	}
+	return b, nil
+}
+
+// Ingests a COPY dump of ipld.blocks into its index, configured by the flags, and returns the index
+func openDump(path string) (ethdb.Database, error) {
+	index := viper.GetString("dump.index")
+	if index == "" {
+		index = path + ".index"
+	}
+	var columns []string
+	if cols := viper.GetString("dump.columns"); cols != "" {
+		columns = strings.Split(cols, ",")
+	}
+	logWithCommand.Infof("Indexing %s in %s", path, index)
+	started := time.Now()
+	db, stats, err := validator.IngestDump(validator.DumpConfig{
+		Path:     path,
+		Format:   viper.GetString("dump.format"),
+		Columns:  columns,
+		Header:   viper.GetBool("dump.header"),
+		IndexDir: index,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stats.Reused {
+		logWithCommand.Infof("Reusing the existing index of %s", path)
+	} else {
+		logWithCommand.Infof("Indexed %d rows in %s", stats.Rows, time.Since(started).Round(time.Second))
+	}
+	return db, nil
+}
+
+// Returns the JSON-RPC backend config for the endpoint, from the flags
+func rpcConfig(url string) validator.RPCConfig {
+	return validator.RPCConfig{
+		URL:           url,
+		BatchSize:     viper.GetInt("rpc.batchSize"),
+		BatchWait:     viper.GetDuration("rpc.batchWait"),
+		MaxConcurrent: viper.GetInt("rpc.concurrency"),
+		Timeout:       viper.GetDuration("rpc.timeout"),
+	}
+}
+
+// Returns the HTTP block source config for the API and URL, from the flags
+func httpBlockConfig(api, url string) validator.HTTPBlockConfig {
+	return validator.HTTPBlockConfig{
+		URL:     url,
+		API:     api,
+		Offline: viper.GetBool("ipfs.offline"),
+		Timeout: viper.GetDuration("ipfs.httpTimeout"),
+	}
+}
+
+// Returns whether the flags select the Postgres backend, i.e. no other backend is given
+func usingPostgres() bool {
+	if len(viper.GetStringSlice("layered.sources")) > 0 {
+		return false
+	}
+	for _, key := range []string{"car.path", "dump.path", "sqlite.path", "rpc.url", "chaindata.path", "ipfs.kubo", "ipfs.gateway", "ipfs.path"} {
+		if viper.GetString(key) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// The replica set opened by newValidator for --database-replicas, whose stats are logged once validation ends
+var replicaSet *validator.ReplicaSet
+
+// Connects to the read replicas, with the pool settings of the database flags
+// With --replica-wait-for-root, replicas are only read from once they have replayed the state root.
+func openReplicas(specs []string, stateRoot common.Hash) (*validator.ReplicaSet, error) {
+	config := validator.ReplicaConfig{
+		HealthInterval: viper.GetDuration("database.replicaHealthInterval"),
+		Wait:           viper.GetDuration("database.replicaWait"),
+	}
+	if viper.GetBool("database.replicaWaitForRoot") {
+		config.StateRoot = stateRoot
+	}
+	var replicas []validator.Replica
+	for _, spec := range specs {
+		var dbConfig validator.Config
+		validator.LoadViper(&dbConfig)
+		dbConfig.URL = spec
+		db, err := validator.Connect(dbConfig)
+		if err != nil {
+			return nil, fmt.Errorf("replica %s: %w", validator.ReplicaName(spec), err)
+		}
+		replicas = append(replicas, validator.Replica{Name: validator.ReplicaName(spec), DB: db})
+	}
+	// the state root's block number is looked up on the primary, as the replicas may still be replaying its block
+	var primary *sqlx.DB
+	if viper.GetInt64("database.maxBlockNumber") < 0 && viper.GetBool("database.boundToRoot") {
+		db, err := validator.NewDB()
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		primary = db
+	}
+	var err error
+	if config.MaxBlockNumber, err = maxBlockNumber(primary, stateRoot); err != nil {
+		return nil, err
+	}
+	return validator.NewReplicaSet(config, replicas...)
+}
+
+// Returns the block number Postgres lookups are bounded to by --max-block-number, or by --bound-to-root the block number
+// of the state root, or nil if they are not bounded
+func maxBlockNumber(db *sqlx.DB, stateRoot common.Hash) (*uint64, error) {
+	if n := viper.GetInt64("database.maxBlockNumber"); n >= 0 {
+		bound := uint64(n)
+		return &bound, nil
+	}
+	if !viper.GetBool("database.boundToRoot") {
+		return nil, nil
+	}
+	n, err := validator.StateRootBlockNumber(db, stateRoot)
+	if err != nil {
+		return nil, fmt.Errorf("looking up the block number of state root %s: %w", stateRoot, err)
+	}
+	return &n, nil
+}
+
+// The layered database opened by newValidator for --layered, whose report is logged once validation ends
+var layeredDB *validator.LayeredDatabase
+
+// Returns a validator over the backend selected by the flags: backends layered in order, a CAR file, a COPY dump, a SQLite database, a JSON-RPC endpoint, geth chaindata, a kubo
+// node or gateway over HTTP, an IPFS repo, or else Postgres
+// The state root is only used to check that a CAR file lists it as a root
+func newValidator(params validator.Params, stateRoot common.Hash) (*validator.Validator, error) {
+	if specs := viper.GetStringSlice("layered.sources"); len(specs) > 0 {
+		var sources []validator.LayeredSource
+		for _, spec := range specs {
+			b, err := openBackend(spec, false, stateRoot)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", spec, err)
+			}
+			sources = append(sources, validator.LayeredSource{Name: spec, Reader: b.reader})
+		}
+		db, err := validator.NewLayeredDatabase(sources...)
+		if err != nil {
+			return nil, err
+		}
+		layeredDB = db
+		return validator.NewLayeredValidator(db, params), nil
+	}
+	if carPath := viper.GetString("car.path"); carPath != "" {
+		car, err := validator.OpenCAR(carPath)
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := validator.CARHasRoot(car, stateRoot); err != nil {
+			return nil, err
+		} else if !ok {
+			logWithCommand.Warnf("state root %s is not a root of %s", stateRoot, carPath)
+		}
+		return validator.NewCARValidator(car, params), nil
+	}
+	if dump := viper.GetString("dump.path"); dump != "" {
+		db, err := openDump(dump)
+		if err != nil {
+			return nil, err
+		}
+		return validator.NewDumpValidator(db, params), nil
+	}
+	if sqlite := viper.GetString("sqlite.path"); sqlite != "" {
+		db, err := validator.OpenSQLite(sqlite, false)
+		if err != nil {
+			return nil, err
+		}
+		return validator.NewSQLiteValidator(db, params), nil
+	}
+	if url := viper.GetString("rpc.url"); url != "" {
+		db, err := validator.DialRPC(context.Background(), rpcConfig(url))
+		if err != nil {
+			return nil, err
+		}
+		return validator.NewRPCValidator(db, params), nil
+	}
+	if chaindata := viper.GetString("chaindata.path"); chaindata != "" {
+		db, err := validator.OpenChaindata(validator.ChaindataConfig{
+			Directory: chaindata,
+			Ancient:   viper.GetString("chaindata.ancient"),
+			Type:      viper.GetString("chaindata.type"),
+			Cache:     viper.GetInt("chaindata.cache"),
+			Handles:   viper.GetInt("chaindata.handles"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return validator.NewChaindataValidator(db, params), nil
+	}
+	for _, api := range []string{validator.KuboAPI, validator.GatewayAPI} {
+		if url := viper.GetString("ipfs." + api); url != "" {
+			bs, err := validator.NewHTTPBlockstore(httpBlockConfig(api, url))
+			if err != nil {
+				return nil, err
+			}
+			return validator.NewHTTPValidator(bs, params), nil
+		}
+	}
+	ipfsPath := viper.GetString("ipfs.path")
+	if specs := viper.GetStringSlice("database.replicas"); ipfsPath == "" && len(specs) > 0 {
+		if viper.GetBool("database.snapshot") {
+			return nil, fmt.Errorf("--database-snapshot cannot be used with --database-replicas, as a snapshot is local to one server")
+		}
+		if viper.GetInt("database.batchSize") > 0 {
+			return nil, fmt.Errorf("--database-batch-size cannot be used with --database-replicas")
+		}
+		set, err := openReplicas(specs, stateRoot)
+		if err != nil {
+			return nil, err
+		}
+		replicaSet = set
+		return validator.NewReplicaValidator(set, params), nil
+	}
+	if ipfsPath == "" {
+		db, err := validator.NewDB()
+		if err != nil {
+			logWithCommand.Fatal(err)
+		}
+		bound, err := maxBlockNumber(db, stateRoot)
+		if err != nil {
+			return nil, err
+		}
+		if bound != nil {
+			logWithCommand.Infof("Reading blocks written at or before block %d", *bound)
+		}
+		batchSize := viper.GetInt("database.batchSize")
+		if viper.GetBool("database.snapshot") {
+			if batchSize > 0 {
+				return nil, fmt.Errorf("--database-batch-size cannot be used with --database-snapshot")
+			}
+			snapshot, err := validator.NewSnapshotDatabase(db, int(params.Workers))
+			if err != nil {
+				return nil, err
+			}
+			snapshot.MaxBlockNumber = bound
+			logWithCommand.Infof("Reading from snapshot %s", snapshot.Snapshot())
+			return validator.NewSnapshotValidator(snapshot, params), nil
+		}
+		if batchSize > 0 {
+			batched := validator.NewBatchedDatabase(db, validator.BatchConfig{
+				BatchSize:      batchSize,
+				BatchWait:      viper.GetDuration("database.batchWait"),
+				MaxConcurrent:  viper.GetInt("database.batchConcurrency"),
+				Prefetch:       viper.GetBool("database.prefetch"),
+				MaxBlockNumber: bound,
+			})
+			return validator.NewBatchedValidator(batched, params), nil
+		}
+		if viper.GetBool("database.prefetch") {
+			return nil, fmt.Errorf("--database-prefetch needs --database-batch-size")
+		}
+		if bound != nil {
+			return validator.NewBoundedValidator(db, *bound, params), nil
+		}
+		return validator.NewPGIPFSValidator(db, params), nil
+	}
+	if viper.GetBool("ipfs.direct") {
+		bs, err := validator.OpenIPFSRepo(ipfsPath)
+		if err != nil {
+			return nil, err
+		}
+		return validator.NewIPFSRepoValidator(bs, params), nil
+	}
+	bs, err := validator.InitIPFSBlockService(ipfsPath)
+	if err != nil {
+		return nil, err
+	}
+	return validator.NewIPFSValidator(bs, params), nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("ipfs-path", "", "Path to IPFS repository; if provided operations move through the IPFS repo otherwise Postgres connection params are expected in the provided config")
+	rootCmd.PersistentFlags().Bool("ipfs-direct", false, "read the blockstore of the --ipfs-path repo directly and read-only, without taking the repo lock or starting a node")
+	rootCmd.PersistentFlags().String("ipfs-api", "", "URL of a kubo node's RPC API, e.g. http://127.0.0.1:5001; if provided blocks are fetched from it over HTTP, without taking the repo lock")
+	rootCmd.PersistentFlags().String("ipfs-gateway", "", "URL of an IPFS trustless gateway; if provided blocks are fetched from it with ?format=raw")
+	rootCmd.PersistentFlags().Bool("ipfs-offline", true, "only read blocks the kubo node already has, rather than searching the network for them")
+	rootCmd.PersistentFlags().Duration("ipfs-http-timeout", time.Minute, "timeout of each block request to the kubo node or gateway")
+	rootCmd.PersistentFlags().StringSlice("layered", nil, "comma-separated backends to look nodes up in, in order, each falling back to the next, e.g. postgres,ipfs:<repo path>; backends are given as for copyState")
+	rootCmd.PersistentFlags().String("car", "", "Path to a CARv1 or CARv2 file; if provided its blocks are read instead")
+	rootCmd.PersistentFlags().String("chaindata", "", "Path to a geth chaindata directory (LevelDB or Pebble); if provided it is opened read-only and read instead")
+	rootCmd.PersistentFlags().String("ancient", "", "Path to the chaindata freezer; defaults to <chaindata>/ancient")
+	rootCmd.PersistentFlags().String("chaindata-type", "", "Chaindata database type: leveldb or pebble; detected if unset")
+	rootCmd.PersistentFlags().Int("chaindata-cache", 512, "Chaindata cache size in megabytes")
+	rootCmd.PersistentFlags().Int("chaindata-handles", 256, "Number of open files for the chaindata database")
+	rootCmd.PersistentFlags().String("dump", "", "Path to a COPY dump of ipld.blocks, gzipped if it ends in .gz; if provided it is indexed on disk and read instead")
+	rootCmd.PersistentFlags().String("dump-format", validator.TextDump, "COPY format of the dump: text, csv or binary")
+	rootCmd.PersistentFlags().String("dump-columns", strings.Join(validator.DefaultDumpColumns, ","), "comma-separated columns of the dump, in order")
+	rootCmd.PersistentFlags().Bool("dump-header", false, "whether a CSV dump starts with a header line")
+	rootCmd.PersistentFlags().String("dump-index", "", "directory of the on-disk index of the dump; defaults to <dump>.index, and is reused for the same dump")
+	rootCmd.PersistentFlags().String("sqlite", "", "Path to a SQLite database with an ipld.blocks table; if provided its blocks are read instead")
+	rootCmd.PersistentFlags().String("rpc", "", "URL of a geth-compatible JSON-RPC endpoint with the debug API; if provided nodes are read from it instead")
+	rootCmd.PersistentFlags().Int("rpc-batch-size", validator.DefaultRPCBatchSize, "maximum lookups per JSON-RPC batch request; 1 disables batching")
+	rootCmd.PersistentFlags().Duration("rpc-batch-wait", validator.DefaultRPCBatchWait, "how long to wait for lookups to fill a JSON-RPC batch")
+	rootCmd.PersistentFlags().Int("rpc-concurrency", validator.DefaultRPCMaxConcurrent, "maximum JSON-RPC requests in flight")
+	rootCmd.PersistentFlags().Duration("rpc-timeout", 30*time.Second, "timeout of each JSON-RPC request")
+	rootCmd.PersistentFlags().Int("workers", 4, "number of concurrent workers to use")
+
+	viper.BindPFlag("validator.workers", rootCmd.PersistentFlags().Lookup("workers"))
+	viper.BindPFlag("ipfs.path", rootCmd.PersistentFlags().Lookup("ipfs-path"))
+	viper.BindPFlag("ipfs.direct", rootCmd.PersistentFlags().Lookup("ipfs-direct"))
+	viper.BindPFlag("ipfs.kubo", rootCmd.PersistentFlags().Lookup("ipfs-api"))
+	viper.BindPFlag("ipfs.gateway", rootCmd.PersistentFlags().Lookup("ipfs-gateway"))
+	viper.BindPFlag("ipfs.offline", rootCmd.PersistentFlags().Lookup("ipfs-offline"))
+	viper.BindPFlag("ipfs.httpTimeout", rootCmd.PersistentFlags().Lookup("ipfs-http-timeout"))
+	viper.BindPFlag("layered.sources", rootCmd.PersistentFlags().Lookup("layered"))
+	viper.BindPFlag("car.path", rootCmd.PersistentFlags().Lookup("car"))
+	viper.BindPFlag("dump.path", rootCmd.PersistentFlags().Lookup("dump"))
+	viper.BindPFlag("dump.format", rootCmd.PersistentFlags().Lookup("dump-format"))
+	viper.BindPFlag("dump.columns", rootCmd.PersistentFlags().Lookup("dump-columns"))
+	viper.BindPFlag("dump.header", rootCmd.PersistentFlags().Lookup("dump-header"))
+	viper.BindPFlag("dump.index", rootCmd.PersistentFlags().Lookup("dump-index"))
+	viper.BindPFlag("sqlite.path", rootCmd.PersistentFlags().Lookup("sqlite"))
+	viper.BindPFlag("rpc.url", rootCmd.PersistentFlags().Lookup("rpc"))
+	viper.BindPFlag("rpc.batchSize", rootCmd.PersistentFlags().Lookup("rpc-batch-size"))
+	viper.BindPFlag("rpc.batchWait", rootCmd.PersistentFlags().Lookup("rpc-batch-wait"))
+	viper.BindPFlag("rpc.concurrency", rootCmd.PersistentFlags().Lookup("rpc-concurrency"))
+	viper.BindPFlag("rpc.timeout", rootCmd.PersistentFlags().Lookup("rpc-timeout"))
+	viper.BindPFlag("chaindata.path", rootCmd.PersistentFlags().Lookup("chaindata"))
+	viper.BindPFlag("chaindata.ancient", rootCmd.PersistentFlags().Lookup("ancient"))
+	viper.BindPFlag("chaindata.type", rootCmd.PersistentFlags().Lookup("chaindata-type"))
+	viper.BindPFlag("chaindata.cache", rootCmd.PersistentFlags().Lookup("chaindata-cache"))
+	viper.BindPFlag("chaindata.handles", rootCmd.PersistentFlags().Lookup("chaindata-handles"))
+}
@@ -0,0 +1,154 @@
+// Copyright © 2023 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var (
+	compareRoot   string
+	compareA      string
+	compareB      string
+	compareOutput string
+)
+
+// compareBackendsCmd represents the compareBackends command
+var compareBackendsCmd = &cobra.Command{
+	Use:   "compareBackends",
+	Short: "Compare the state for a root between two backends node-for-node",
+	Long: `This command traverses the full state for a root in two backends at once and reports every state node, storage
+node and code blob present in only one of them, or whose bytes differ between them, with its path
+
+Backends are given as for copyState, e.g.
+
+./eth-ipfs-state-validator compareBackends --root={state root hex string} --a=postgres --b=ipfs:/data/ipfs
+
+The traversal follows the nodes of either backend, so the subtries below a node only one backend has are compared too.
+Each difference is logged, or with --output written to a file as tab-separated lines of the difference (only-a, only-b,
+differ or missing-both), kind, CID, state path and storage path, with the paths as hex nibbles. Nodes missing from both
+backends are reported as missing-both and their subtries skipped.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *logrus.WithField("SubCommand", subCommand)
+		compareBackends()
+	},
+}
+
+func compareBackends() {
+	if compareRoot == "" {
+		logWithCommand.Fatal("must provide a state root to compare")
+	}
+	if compareA == "" || compareB == "" {
+		logWithCommand.Fatal("must provide two backends to compare")
+	}
+	stateRoot := common.HexToHash(compareRoot)
+	params := validator.Params{
+		Workers:          viper.GetUint("validator.workers"),
+		ProgressInterval: viper.GetDuration("validator.progressInterval"),
+		ProgressBar:      viper.GetBool("validator.progressBar"),
+	}
+	a, err := openBackend(compareA, false, stateRoot)
+	if err != nil {
+		logWithCommand.Fatalf("Failed to open backend a: %v", err)
+	}
+	b, err := openBackend(compareB, false, stateRoot)
+	if err != nil {
+		logWithCommand.Fatalf("Failed to open backend b: %v", err)
+	}
+
+	report := func(diff validator.NodeDifference) error {
+		logWithCommand.WithFields(logrus.Fields{
+			"kind":        diff.Kind,
+			"cid":         diff.CID,
+			"statePath":   nibbles(diff.StatePath),
+			"storagePath": nibbles(diff.StoragePath),
+		}).Warn(describeDifference(diff.Difference))
+		return nil
+	}
+	var out *bufio.Writer
+	if compareOutput != "" {
+		f, err := os.Create(compareOutput)
+		if err != nil {
+			logWithCommand.Fatal(err)
+		}
+		defer f.Close()
+		out = bufio.NewWriter(f)
+		report = func(diff validator.NodeDifference) error {
+			_, err := fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n",
+				diff.Difference, diff.Kind, diff.CID, nibbles(diff.StatePath), nibbles(diff.StoragePath))
+			return err
+		}
+	}
+
+	started := time.Now()
+	stats, err := validator.CompareState(stateRoot, a.reader, b.reader, params, report)
+	if out != nil {
+		if ferr := out.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	if err != nil {
+		logWithCommand.Fatalf("Comparison failed after %d differences: %v", stats.Differences, err)
+	}
+	logWithCommand.Infof("Compared %d nodes and %d code blobs for state root %s in %s: %d differences",
+		stats.Nodes, stats.Code, stateRoot, time.Since(started).Round(time.Second), stats.Differences)
+}
+
+// Returns a log message for a difference
+func describeDifference(diff validator.Difference) string {
+	switch diff {
+	case validator.OnlyInA:
+		return "Node missing from " + compareB
+	case validator.OnlyInB:
+		return "Node missing from " + compareA
+	case validator.MissingBoth:
+		return "Node missing from both backends"
+	default:
+		return "Node differs between backends"
+	}
+}
+
+// Formats a path of nibbles as hex digits, dropping the terminator of leaf paths
+func nibbles(path []byte) string {
+	var sb strings.Builder
+	for _, n := range path {
+		if n < 16 {
+			sb.WriteByte("0123456789abcdef"[n])
+		}
+	}
+	return sb.String()
+}
+
+func init() {
+	rootCmd.AddCommand(compareBackendsCmd)
+
+	compareBackendsCmd.Flags().StringVar(&compareRoot, "root", "", "root of the state to compare")
+	compareBackendsCmd.Flags().StringVar(&compareA, "a", "", "first backend: postgres, postgres://..., ipfs:<path>, ipfs-repo:<path>, chaindata:<dir>, sqlite:<file>, car:<file>, dump:<file>, rpc:<url>, kubo:<url> or gateway:<url>")
+	compareBackendsCmd.Flags().StringVar(&compareB, "b", "", "second backend, in the same form as --a")
+	compareBackendsCmd.Flags().StringVar(&compareOutput, "output", "", "file to write the differences to as tab-separated lines, rather than logging them")
+}
@@ -0,0 +1,147 @@
+// Copyright © 2023 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var (
+	copyFrom        string
+	copyTo          string
+	copyRoot        string
+	copyBlockNumber int64
+)
+
+// copyStateCmd represents the copyState command
+var copyStateCmd = &cobra.Command{
+	Use:   "copyState",
+	Short: "Copy the state for a root from one backend to another",
+	Long: `This command traverses the full state for a root in the source backend and writes every state node, storage node
+and code blob into the destination backend, keyed by CID, then validates the root on the destination
+
+Backends are given as:
+
+postgres             the database configured by --config
+postgres://...       a Postgres connection string
+ipfs:<repo path>     an IPFS repository
+ipfs-repo:<path>     an IPFS repository's blockstore, read directly without the repo lock; as a source only
+chaindata:<dir>      a geth LevelDB or Pebble database
+sqlite:<file>        a SQLite database with an ipld.blocks table, created if it does not exist
+car:<file>           a CAR file, written as CARv2
+dump:<file>          a COPY dump of ipld.blocks, indexed on disk; as a source only
+rpc:<url>            a geth-compatible JSON-RPC endpoint; as a source only
+kubo:<url>           a kubo node's RPC API; as a source only
+gateway:<url>        an IPFS trustless gateway; as a source only
+
+./eth-ipfs-state-validator copyState --from=postgres://source/cerc_public --to=chaindata:/data/geth/chaindata --root={state root hex string}
+
+Blocks written to Postgres or SQLite are indexed at --block-number, which defaults to the block number of the root in
+the source when it also has an ipld.blocks table. An interrupted copy resumes from its recovery state, as validation does.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *logrus.WithField("SubCommand", subCommand)
+		copyState()
+	},
+}
+
+func copyState() {
+	if copyRoot == "" {
+		logWithCommand.Fatal("must provide a state root to copy")
+	}
+	if copyFrom == "" || copyTo == "" {
+		logWithCommand.Fatal("must provide a source and destination backend")
+	}
+	stateRoot := common.HexToHash(copyRoot)
+	recoveryStore, err := newRecoveryStore()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	params := validator.Params{
+		Workers:          viper.GetUint("validator.workers"),
+		RecoveryFormat:   viper.GetString("validator.recoveryFormat"),
+		RecoveryStore:    recoveryStore,
+		ProgressInterval: viper.GetDuration("validator.progressInterval"),
+		ProgressBar:      viper.GetBool("validator.progressBar"),
+	}
+
+	src, err := openBackend(copyFrom, false, stateRoot)
+	if err != nil {
+		logWithCommand.Fatalf("Failed to open source: %v", err)
+	}
+	dst, err := openBackend(copyTo, true, stateRoot)
+	if err != nil {
+		logWithCommand.Fatalf("Failed to open destination: %v", err)
+	}
+	if dst.writer == nil {
+		logWithCommand.Fatalf("Backend cannot be written to: %s", copyTo)
+	}
+	blockNumber := uint64(copyBlockNumber)
+	if dst.pg != nil && copyBlockNumber < 0 {
+		if src.pg == nil {
+			logWithCommand.Fatal("must provide --block-number to copy into ipld.blocks from a backend without block numbers")
+		}
+		if blockNumber, err = validator.StateRootBlockNumber(src.pg, stateRoot); err != nil {
+			logWithCommand.Fatalf("Failed to look up the block number of the state root: %v", err)
+		}
+	}
+	writer, err := dst.writer(blockNumber)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+
+	v, err := src.validator(params)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	started := time.Now()
+	stats, err := v.CopyState(stateRoot, writer)
+	if err != nil {
+		logWithCommand.Fatalf("Copy failed: %v", err)
+	}
+	logWithCommand.Infof("Copied %d nodes and %d code blobs for state root %s from %s to %s in %s",
+		stats.Nodes, stats.Code, stateRoot, copyFrom, copyTo, time.Since(started).Round(time.Second))
+	// release the source's caches before the destination's validator registers its own
+	if err := v.Close(); err != nil {
+		logWithCommand.Fatal(err)
+	}
+
+	v, err = dst.validator(params)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	defer v.Close()
+	if err := v.ValidateTrie(stateRoot); err != nil {
+		logWithCommand.Fatalf("State root %s is incomplete at the destination: %v", stateRoot, err)
+	}
+	logWithCommand.Infof("State root %s is complete at the destination", stateRoot)
+}
+
+func init() {
+	rootCmd.AddCommand(copyStateCmd)
+
+	copyStateCmd.Flags().StringVar(&copyFrom, "from", "", "backend to copy from: postgres, postgres://..., ipfs:<path>, ipfs-repo:<path>, chaindata:<dir>, car:<file>, dump:<file>, rpc:<url>, kubo:<url> or gateway:<url>")
+	copyStateCmd.Flags().StringVar(&copyTo, "to", "", "backend to copy to, in the same form as --from")
+	copyStateCmd.Flags().StringVar(&copyRoot, "root", "", "root of the state to copy")
+	copyStateCmd.Flags().Int64Var(&copyBlockNumber, "block-number", -1, "block number to index blocks written to Postgres at; defaults to the root's block number in a Postgres source")
+}
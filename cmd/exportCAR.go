@@ -0,0 +1,88 @@
+// Copyright © 2023 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var (
+	exportStateRoot string
+	exportOutput    string
+	exportShards    uint
+)
+
+// exportCARCmd represents the exportCAR command
+var exportCARCmd = &cobra.Command{
+	Use:   "exportCAR",
+	Short: "Export the state for a root to CAR files",
+	Long: `This command traverses the full state for a root, as a full validation does, and writes every unique state node,
+storage node and code blob to a CARv1 file rooted at the state root's CID
+
+The state is read from the same backends as validateTrie: --car, --chaindata, --ipfs-path or Postgres.
+
+./eth-ipfs-state-validator exportCAR --config={path to db config} --state-root={state root hex string} --output=state.car
+
+With --shards the state is split by state trie path into that many files, each with the storage tries and code of the
+accounts in it. The output path must then contain %d, which is substituted with the shard index.
+
+./eth-ipfs-state-validator exportCAR --config={path to db config} --state-root={state root hex string} --shards=16 --output=state-%02d.car`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *logrus.WithField("SubCommand", subCommand)
+		exportCAR()
+	},
+}
+
+func exportCAR() {
+	if exportStateRoot == "" {
+		logWithCommand.Fatal("must provide a state root to export")
+	}
+	stateRoot := common.HexToHash(exportStateRoot)
+	params := validator.Params{
+		Workers:          viper.GetUint("validator.workers"),
+		ProgressInterval: viper.GetDuration("validator.progressInterval"),
+	}
+	v, err := newValidator(params, stateRoot)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	defer v.Close()
+
+	started := time.Now()
+	stats, err := v.ExportCAR(stateRoot, validator.ExportParams{Path: exportOutput, Shards: exportShards})
+	if err != nil {
+		logWithCommand.Fatalf("Export failed: %v", err)
+	}
+	logWithCommand.Infof("Exported %d blocks for state root %s to %s in %s",
+		stats.Blocks, stateRoot, strings.Join(stats.Files, ", "), time.Since(started).Round(time.Second))
+}
+
+func init() {
+	rootCmd.AddCommand(exportCARCmd)
+
+	exportCARCmd.Flags().StringVar(&exportStateRoot, "state-root", "", "root of the state to export")
+	exportCARCmd.Flags().StringVar(&exportOutput, "output", "state.car", "path of the CAR file to write; must contain %d with more than one shard")
+	exportCARCmd.Flags().UintVar(&exportShards, "shards", 1, "number of CAR files to split the state across, by state trie path; must be a power of two")
+}
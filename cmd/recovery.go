@@ -0,0 +1,200 @@
+// Copyright © 2023 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var (
+	recoveryType      string
+	recoveryRoot      string
+	recoveryOlderThan time.Duration
+	recoveryClearAll  bool
+)
+
+// recoveryCmd represents the recovery command group
+var recoveryCmd = &cobra.Command{
+	Use:   "recovery",
+	Short: "Inspect and manage saved recovery state",
+	Long: `These commands operate on the recovery state saved by interrupted or failed validations
+
+The recovery state is looked up using the configured --recovery-format and --recovery-store.
+
+./eth-ipfs-state-validator recovery list
+./eth-ipfs-state-validator recovery show --type=full
+./eth-ipfs-state-validator recovery clear --older-than=72h`,
+}
+
+var recoveryListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved recovery state",
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *logrus.WithField("SubCommand", subCommand)
+		recoveryList()
+	},
+}
+
+var recoveryShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the saved position of each worker",
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *logrus.WithField("SubCommand", subCommand)
+		recoveryShow()
+	},
+}
+
+var recoveryClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete saved recovery state",
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *logrus.WithField("SubCommand", subCommand)
+		recoveryClear()
+	},
+}
+
+func recoveryList() {
+	records := recoveryRecords(mustRecoveryStore())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOT\tTYPE\tAGE\tWORKERS\tPROGRESS\tLOCATION")
+	for _, rec := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.2f%%\t%s\n",
+			formatRecoveryRoot(rec.Root), rec.Traversal, time.Since(rec.Updated).Round(time.Second),
+			len(rec.Positions), rec.Progress()*100, rec.Location)
+	}
+	w.Flush()
+}
+
+func recoveryShow() {
+	for _, rec := range recoveryRecords(mustRecoveryStore()) {
+		fmt.Printf("root: %s\ntype: %s\nlocation: %s\nupdated: %s\nprogress: %.2f%%\n",
+			formatRecoveryRoot(rec.Root), rec.Traversal, rec.Location, rec.Updated.Format(time.RFC3339), rec.Progress()*100)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "WORKER\tPATH\tEND PATH\tREMAINING")
+		for i, pos := range rec.Positions {
+			fmt.Fprintf(w, "%d\t%x\t%x\t%.2f%%\n", i, pos.Path, pos.EndPath, pos.Remaining()*100)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+}
+
+func recoveryClear() {
+	if !recoveryClearAll && recoveryType == "" && recoveryRoot == "" && recoveryOlderThan == 0 {
+		logWithCommand.Fatal("refusing to clear all recovery state without --all; use --type, --root or --older-than to select")
+	}
+	store := mustRecoveryStore()
+	for _, rec := range recoveryRecords(store) {
+		if recoveryOlderThan != 0 && time.Since(rec.Updated) < recoveryOlderThan {
+			continue
+		}
+		unlock, err := store.Lock(rec.Root, rec.Traversal, recoveryFile(rec.Traversal))
+		if err != nil {
+			logWithCommand.Warnf("Skipping %s: %v", rec.Location, err)
+			continue
+		}
+		err = store.Clear(rec)
+		unlock()
+		if err != nil {
+			logWithCommand.Fatalf("failed to clear recovery state at %s: %v", rec.Location, err)
+		}
+		logWithCommand.Infof("Cleared %s recovery state for root %s at %s", rec.Traversal, formatRecoveryRoot(rec.Root), rec.Location)
+	}
+}
+
+// Returns the saved recovery state matching the --type and --root filters
+func recoveryRecords(store validator.RecoveryStore) []validator.RecoveryRecord {
+	records, err := store.List()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	var ret []validator.RecoveryRecord
+	for _, rec := range records {
+		if recoveryType != "" && rec.Traversal != strings.ToLower(recoveryType) {
+			continue
+		}
+		if recoveryRoot != "" && rec.Root != common.HexToHash(recoveryRoot) {
+			continue
+		}
+		ret = append(ret, rec)
+	}
+	return ret
+}
+
+func mustRecoveryStore() validator.RecoveryStore {
+	store, err := newRecoveryStore()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	return store
+}
+
+func formatRecoveryRoot(root common.Hash) string {
+	if root == (common.Hash{}) {
+		return "-"
+	}
+	return root.Hex()
+}
+
+func recoveryFormat() string {
+	if format := viper.GetString("validator.recoveryFormat"); format != "" {
+		return format
+	}
+	return validator.DefaultRecoveryFormat
+}
+
+func recoveryFile(traversal validator.TraversalType) string {
+	return fmt.Sprintf(recoveryFormat(), traversal)
+}
+
+func newRecoveryStore() (validator.RecoveryStore, error) {
+	switch store := strings.ToLower(viper.GetString("validator.recoveryStore")); store {
+	case "", "file":
+		return validator.FileRecoveryStore{Format: recoveryFormat()}, nil
+	case "postgres", "pg":
+		db, err := validator.NewDB()
+		if err != nil {
+			return nil, err
+		}
+		return validator.NewPGRecoveryStore(db)
+	default:
+		return nil, fmt.Errorf("invalid recovery store: '%s'", store)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(recoveryCmd)
+	recoveryCmd.AddCommand(recoveryListCmd, recoveryShowCmd, recoveryClearCmd)
+
+	recoveryCmd.PersistentFlags().StringVar(&recoveryType, "type", "", "only consider recovery state for this traversal type: full, state, storage")
+	recoveryCmd.PersistentFlags().StringVar(&recoveryRoot, "root", "", "only consider recovery state for this root; not applicable to recovery files")
+	recoveryClearCmd.Flags().DurationVar(&recoveryOlderThan, "older-than", 0, "only clear recovery state last updated longer ago than this")
+	recoveryClearCmd.Flags().BoolVar(&recoveryClearAll, "all", false, "clear all recovery state matching the other filters, or all of it if none are given")
+}
@@ -19,10 +19,13 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
 )
 
 var (
@@ -86,11 +89,35 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file location")
 	rootCmd.PersistentFlags().String("logfile", "", "file path for logging")
 	rootCmd.PersistentFlags().String("database-name", "cerc_public", "database name")
-	rootCmd.PersistentFlags().Int("database-port", 5432, "database port")
-	rootCmd.PersistentFlags().String("database-hostname", "localhost", "database hostname")
+	rootCmd.PersistentFlags().Int("database-port", 0, "database port; defaults to PGPORT, or else 5432")
+	rootCmd.PersistentFlags().String("database-hostname", "", "database hostname; defaults to PGHOST, or else localhost")
 	rootCmd.PersistentFlags().String("database-user", "", "database user")
-	rootCmd.PersistentFlags().String("database-password", "", "database password")
+	rootCmd.PersistentFlags().String("database-password", "", "database password; defaults to PGPASSWORD, or else the password file at PGPASSFILE or ~/.pgpass")
+	rootCmd.PersistentFlags().String("database-url", "", "database connection string, as a postgres:// URL or key=value pairs; if provided it is used instead of the other connection flags")
+	rootCmd.PersistentFlags().String("database-sslmode", "", "TLS mode: disable, require, verify-ca or verify-full; defaults to PGSSLMODE, or else disable")
+	rootCmd.PersistentFlags().String("database-sslcert", "", "client certificate file for TLS")
+	rootCmd.PersistentFlags().String("database-sslkey", "", "client key file for TLS")
+	rootCmd.PersistentFlags().String("database-sslrootcert", "", "CA certificate file the server certificate is verified against, for verify-ca and verify-full")
+	rootCmd.PersistentFlags().Duration("database-connect-timeout", 0, "timeout for connecting to the database; 0 waits indefinitely")
+	rootCmd.PersistentFlags().String("database-application-name", "", "application_name reported to the database; defaults to PGAPPNAME")
+	rootCmd.PersistentFlags().Int("database-max-open-conns", 0, "maximum open connections to the database; defaults to the number of workers plus 2")
+	rootCmd.PersistentFlags().Int("database-max-idle-conns", 0, "maximum idle connections kept open; defaults to the maximum open connections")
+	rootCmd.PersistentFlags().Duration("database-conn-max-lifetime", 0, "connections are closed after this long; 0 keeps them open")
+	rootCmd.PersistentFlags().Duration("database-conn-max-idle-time", 0, "idle connections are closed after this long; 0 keeps them open")
+	rootCmd.PersistentFlags().Bool("database-snapshot", false, "read the database through REPEATABLE READ transactions sharing one exported snapshot, so a run sees one consistent state")
+	rootCmd.PersistentFlags().Int64("max-block-number", -1, "only read blocks written at or before this block number, so a partitioned ipld.blocks is pruned to the partitions up to it")
+	rootCmd.PersistentFlags().Bool("bound-to-root", false, "only read blocks written at or before the block number of the state root")
+	rootCmd.PersistentFlags().Int("database-batch-size", 0, "read the database in WHERE key = ANY($1) queries of up to this many keys, collecting the lookups of all workers; 0 reads one key per query")
+	rootCmd.PersistentFlags().Duration("database-batch-wait", validator.DefaultBatchWait, "how long to wait for lookups to fill a database batch")
+	rootCmd.PersistentFlags().Int("database-batch-concurrency", validator.DefaultBatchMaxConc, "maximum database batch queries in flight")
+	rootCmd.PersistentFlags().Bool("database-prefetch", false, "with batched reads, also fetch the children of each branch and extension node as soon as it arrives")
+	rootCmd.PersistentFlags().StringSlice("database-replicas", nil, "connection strings of read replicas to spread node lookups across, instead of the database")
+	rootCmd.PersistentFlags().Duration("replica-health-interval", 5*time.Second, "interval between health checks of the replicas")
+	rootCmd.PersistentFlags().Bool("replica-wait-for-root", false, "only read from a replica once it has replayed the state root")
+	rootCmd.PersistentFlags().Duration("replica-wait", 0, "how long to wait at startup for a replica to become healthy")
 	rootCmd.PersistentFlags().String("log-level", logrus.InfoLevel.String(), "Log level (trace, debug, info, warn, error, fatal, panic")
+	rootCmd.PersistentFlags().String("recovery-format", validator.DefaultRecoveryFormat, "format pattern for recovery files")
+	rootCmd.PersistentFlags().String("recovery-store", "file", "where recovery state is persisted: file, postgres")
 
 	viper.BindPFlag("logfile", rootCmd.PersistentFlags().Lookup("logfile"))
 	viper.BindPFlag("database.name", rootCmd.PersistentFlags().Lookup("database-name"))
@@ -98,7 +125,31 @@ func init() {
 	viper.BindPFlag("database.hostname", rootCmd.PersistentFlags().Lookup("database-hostname"))
 	viper.BindPFlag("database.user", rootCmd.PersistentFlags().Lookup("database-user"))
 	viper.BindPFlag("database.password", rootCmd.PersistentFlags().Lookup("database-password"))
+	viper.BindPFlag("database.url", rootCmd.PersistentFlags().Lookup("database-url"))
+	viper.BindPFlag("database.sslmode", rootCmd.PersistentFlags().Lookup("database-sslmode"))
+	viper.BindPFlag("database.sslcert", rootCmd.PersistentFlags().Lookup("database-sslcert"))
+	viper.BindPFlag("database.sslkey", rootCmd.PersistentFlags().Lookup("database-sslkey"))
+	viper.BindPFlag("database.sslrootcert", rootCmd.PersistentFlags().Lookup("database-sslrootcert"))
+	viper.BindPFlag("database.connectTimeout", rootCmd.PersistentFlags().Lookup("database-connect-timeout"))
+	viper.BindPFlag("database.applicationName", rootCmd.PersistentFlags().Lookup("database-application-name"))
+	viper.BindPFlag("database.maxOpenConns", rootCmd.PersistentFlags().Lookup("database-max-open-conns"))
+	viper.BindPFlag("database.maxIdleConns", rootCmd.PersistentFlags().Lookup("database-max-idle-conns"))
+	viper.BindPFlag("database.connMaxLifetime", rootCmd.PersistentFlags().Lookup("database-conn-max-lifetime"))
+	viper.BindPFlag("database.connMaxIdleTime", rootCmd.PersistentFlags().Lookup("database-conn-max-idle-time"))
+	viper.BindPFlag("database.snapshot", rootCmd.PersistentFlags().Lookup("database-snapshot"))
+	viper.BindPFlag("database.maxBlockNumber", rootCmd.PersistentFlags().Lookup("max-block-number"))
+	viper.BindPFlag("database.boundToRoot", rootCmd.PersistentFlags().Lookup("bound-to-root"))
+	viper.BindPFlag("database.batchSize", rootCmd.PersistentFlags().Lookup("database-batch-size"))
+	viper.BindPFlag("database.batchWait", rootCmd.PersistentFlags().Lookup("database-batch-wait"))
+	viper.BindPFlag("database.batchConcurrency", rootCmd.PersistentFlags().Lookup("database-batch-concurrency"))
+	viper.BindPFlag("database.prefetch", rootCmd.PersistentFlags().Lookup("database-prefetch"))
+	viper.BindPFlag("database.replicas", rootCmd.PersistentFlags().Lookup("database-replicas"))
+	viper.BindPFlag("database.replicaHealthInterval", rootCmd.PersistentFlags().Lookup("replica-health-interval"))
+	viper.BindPFlag("database.replicaWaitForRoot", rootCmd.PersistentFlags().Lookup("replica-wait-for-root"))
+	viper.BindPFlag("database.replicaWait", rootCmd.PersistentFlags().Lookup("replica-wait"))
 	viper.BindPFlag("log.level", rootCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("validator.recoveryFormat", rootCmd.PersistentFlags().Lookup("recovery-format"))
+	viper.BindPFlag("validator.recoveryStore", rootCmd.PersistentFlags().Lookup("recovery-store"))
 }
 
 func initConfig() {
@@ -16,10 +16,18 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	_ "github.com/lib/pq" //postgres driver
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -33,7 +41,10 @@ var validateTrieCmd = &cobra.Command{
 	Short: "Validate completeness of state data on IPFS",
 	Long: `This command is used to validate the completeness of state data corresponding specific to a specific root
 
-If an ipfs-path is provided it will use a blockservice, otherwise it expects Postgres db configuration in a linked config file.
+If an ipfs-path is provided it will use a blockservice, if a chaindata path is provided it will read a geth LevelDB or Pebble
+database, if a CAR file is provided it will read its blocks, if a COPY dump of ipld.blocks is
+provided it will index and read it, if a SQLite database is provided it will read its ipld.blocks table, if a JSON-RPC URL is provided it will read nodes from a running
+node with debug_dbGet, if a kubo API or gateway URL is provided it will fetch blocks over HTTP, otherwise it expects Postgres db configuration in a linked config file.
 
 It can operate at three levels:
 
@@ -59,14 +70,26 @@ It can operate at three levels:
 }
 
 func validateTrie() {
+	recoveryStore, err := newRecoveryStore()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
 	params := validator.Params{
 		Workers:        viper.GetUint("validator.workers"),
 		RecoveryFormat: viper.GetString("validator.recoveryFormat"),
+		RecoveryStore:  recoveryStore,
+
+		ProgressInterval: viper.GetDuration("validator.progressInterval"),
+		ProgressBar:      viper.GetBool("validator.progressBar"),
+
+		TraceStorageThreshold: viper.GetUint("tracing.storageThreshold"),
+		TraceSlowFetch:        viper.GetDuration("tracing.slowFetch"),
 	}
-	v, err := newValidator(params)
+	stopTracing, err := initTracing()
 	if err != nil {
 		logWithCommand.Fatal(err)
 	}
+	defer stopTracing()
 	stateRootStr := viper.GetString("validator.stateRoot")
 	storageRootStr := viper.GetString("validator.storageRoot")
 	contractAddrStr := viper.GetString("validator.address")
@@ -76,6 +99,43 @@ func validateTrie() {
 	}
 	stateRoot := common.HexToHash(stateRootStr)
 
+	if source := viper.GetString("repair.from"); source != "" {
+		repairer, closeLog, err := newRepairer(source, stateRoot)
+		if err != nil {
+			logWithCommand.Fatalf("Failed to set up repair: %v", err)
+		}
+		defer closeLog()
+		defer func() {
+			logWithCommand.Infof("Repaired %d missing nodes from %s", len(repairer.Repaired()), source)
+		}()
+		params.Repair = repairer
+	}
+	v, err := newValidator(params, stateRoot)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	if layeredDB != nil {
+		defer layeredDB.Close()
+		closeReport, err := openLayeredReport(layeredDB)
+		if err != nil {
+			logWithCommand.Fatalf("Failed to open the layered report: %v", err)
+		}
+		// failed validations exit through Fatal, which skips deferred calls but runs exit handlers
+		report := func() {
+			reportLayers(layeredDB.Report())
+			if err := closeReport(); err != nil {
+				logWithCommand.Errorf("Failed to write the layered report: %v", err)
+			}
+		}
+		logrus.RegisterExitHandler(report)
+		defer report()
+	}
+	if addr := viper.GetString("metrics.addr"); addr != "" {
+		if err := serveMetrics(addr, v); err != nil {
+			logWithCommand.Fatal(err)
+		}
+	}
+
 	traversal := strings.ToLower(viper.GetString("validator.type"))
 	switch traversal {
 	case "f", "full":
@@ -117,22 +177,101 @@ func validateTrie() {
 
 	stats := v.GetCacheStats()
 	logWithCommand.Debugf("groupcache stats %+v", stats)
+	if replicaSet != nil {
+		for _, replica := range replicaSet.Stats() {
+			logWithCommand.Infof("Replica %s served %d lookups, with %d failures", replica.Name, replica.Lookups, replica.Failures)
+		}
+	}
+}
+
+// Streams the nodes only a fallback has to the --layered-report file, if set, returning a function which finishes it
+func openLayeredReport(db *validator.LayeredDatabase) (func() error, error) {
+	path := viper.GetString("layered.report")
+	if path == "" {
+		return func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	db.FallbackLog = w
+	return func() error {
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}, nil
+}
+
+// Logs which of the --layered backends served each kind of node
+func reportLayers(report validator.LayeredReport) {
+	for _, spec := range viper.GetStringSlice("layered.sources") {
+		served := report.Served[spec]
+		logWithCommand.Infof("%s served %d state nodes, %d storage nodes and %d code blobs",
+			spec, served["state"], served["storage"], served["code"])
+	}
+	if len(report.Missing) > 0 {
+		logWithCommand.Warnf("Missing from every backend: %d state nodes, %d storage nodes and %d code blobs",
+			report.Missing["state"], report.Missing["storage"], report.Missing["code"])
+	}
+	logWithCommand.Infof("%d nodes were missing from %s and found in a fallback", report.Fallbacks, viper.GetStringSlice("layered.sources")[0])
 }
 
-func newValidator(params validator.Params) (*validator.Validator, error) {
-	ipfsPath := viper.GetString("ipfs.path")
-	if ipfsPath == "" {
-		db, err := validator.NewDB()
+// Returns a repairer which fills nodes missing from Postgres from the source backend, logging them to the repair log
+// Repaired blocks are inserted at --repair-block-number, or else the block number of the state root
+func newRepairer(source string, stateRoot common.Hash) (*validator.Repairer, func(), error) {
+	if !usingPostgres() {
+		return nil, nil, fmt.Errorf("repair is only supported for the Postgres backend")
+	}
+	src, err := openBackend(source, false, stateRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := validator.NewDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	blockNumber := viper.GetInt64("repair.blockNumber")
+	if blockNumber < 0 {
+		n, err := validator.StateRootBlockNumber(db, stateRoot)
 		if err != nil {
-			logWithCommand.Fatal(err)
+			return nil, nil, fmt.Errorf("looking up the block number of the state root: %w", err)
 		}
-		return validator.NewPGIPFSValidator(db, params), nil
+		blockNumber = int64(n)
 	}
-	bs, err := validator.InitIPFSBlockService(ipfsPath)
+	logFile, err := os.OpenFile(viper.GetString("repair.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	repairer := &validator.Repairer{
+		Source: src.reader,
+		Dest:   validator.NewPGIPFSWriter(db, uint64(blockNumber)),
+		Log:    logFile,
 	}
-	return validator.NewIPFSValidator(bs, params), nil
+	return repairer, func() { logFile.Close() }, nil
+}
+
+// Serves the validator's metrics to Prometheus in the background
+func serveMetrics(addr string, v *validator.Validator) error {
+	if err := validator.RegisterMetrics(prometheus.DefaultRegisterer, v); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	// listen before returning, so a run does not go ahead without the metrics it was asked to serve
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("serving metrics: %w", err)
+	}
+	logWithCommand.Infof("Serving metrics on %s/metrics", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logWithCommand.Errorf("metrics server failed: %v", err)
+		}
+	}()
+	return nil
 }
 
 func init() {
@@ -142,15 +281,35 @@ func init() {
 	validateTrieCmd.PersistentFlags().String("type", "", "Type of validations: full, state, storage")
 	validateTrieCmd.PersistentFlags().String("storage-root", "", "Root of the storage trie we wish to validate; for storage validation")
 	validateTrieCmd.PersistentFlags().String("address", "", "Contract address for the storage trie we wish to validate; for storage validation")
-	validateTrieCmd.PersistentFlags().String("ipfs-path", "", "Path to IPFS repository; if provided operations move through the IPFS repo otherwise Postgres connection params are expected in the provided config")
-	validateTrieCmd.PersistentFlags().Int("workers", 4, "number of concurrent workers to use")
-	validateTrieCmd.PersistentFlags().String("recovery-format", validator.DefaultRecoveryFormat, "format pattern for recovery files")
+	validateTrieCmd.PersistentFlags().Duration("progress-interval", time.Minute, "interval between progress reports; 0 disables them")
+	validateTrieCmd.PersistentFlags().String("metrics-addr", "", "address to serve Prometheus metrics on during the run, e.g. :9090")
+	validateTrieCmd.PersistentFlags().Bool("progress-bar", false, "draw a progress bar on stderr instead of logging progress, if it is a terminal")
+	validateTrieCmd.PersistentFlags().String("tracing-exporter", "none", "OpenTelemetry trace exporter: none, otlp, stdout, file")
+	validateTrieCmd.PersistentFlags().String("tracing-endpoint", "", "host:port of the OTLP/HTTP collector; defaults to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318")
+	validateTrieCmd.PersistentFlags().Bool("tracing-insecure", false, "export to the OTLP collector without TLS")
+	validateTrieCmd.PersistentFlags().String("tracing-file", "", "file to write spans to, as JSON; for the file exporter")
+	validateTrieCmd.PersistentFlags().Uint("trace-storage-threshold", 1000, "storage tries with at least this many nodes get their own span; 0 disables them")
+	validateTrieCmd.PersistentFlags().Duration("trace-slow-fetch", 100*time.Millisecond, "node fetches slower than this are recorded as span events; 0 disables them")
+	validateTrieCmd.PersistentFlags().String("repair-from", "", "backend to fill missing nodes in Postgres from: postgres://..., ipfs:<path>, ipfs-repo:<path>, chaindata:<dir>, sqlite:<file>, car:<file>, dump:<file>, rpc:<url>, kubo:<url> or gateway:<url>")
+	validateTrieCmd.PersistentFlags().String("layered-report", "", "file listing the nodes missing from the first --layered backend and found in a fallback, as tab-separated kind, CID and backend")
+	validateTrieCmd.PersistentFlags().String("repair-log", "repair.log", "file the repaired keys are appended to")
+	validateTrieCmd.PersistentFlags().Int64("repair-block-number", -1, "block number to insert repaired blocks at; defaults to the block number of the state root")
 
 	viper.BindPFlag("validator.stateRoot", validateTrieCmd.PersistentFlags().Lookup("state-root"))
 	viper.BindPFlag("validator.type", validateTrieCmd.PersistentFlags().Lookup("type"))
 	viper.BindPFlag("validator.storageRoot", validateTrieCmd.PersistentFlags().Lookup("storage-root"))
 	viper.BindPFlag("validator.address", validateTrieCmd.PersistentFlags().Lookup("address"))
-	viper.BindPFlag("validator.workers", validateTrieCmd.PersistentFlags().Lookup("workers"))
-	viper.BindPFlag("validator.recoveryFormat", validateTrieCmd.PersistentFlags().Lookup("recovery-format"))
-	viper.BindPFlag("ipfs.path", validateTrieCmd.PersistentFlags().Lookup("ipfs-path"))
+	viper.BindPFlag("validator.progressInterval", validateTrieCmd.PersistentFlags().Lookup("progress-interval"))
+	viper.BindPFlag("validator.progressBar", validateTrieCmd.PersistentFlags().Lookup("progress-bar"))
+	viper.BindPFlag("metrics.addr", validateTrieCmd.PersistentFlags().Lookup("metrics-addr"))
+	viper.BindPFlag("tracing.exporter", validateTrieCmd.PersistentFlags().Lookup("tracing-exporter"))
+	viper.BindPFlag("tracing.endpoint", validateTrieCmd.PersistentFlags().Lookup("tracing-endpoint"))
+	viper.BindPFlag("tracing.insecure", validateTrieCmd.PersistentFlags().Lookup("tracing-insecure"))
+	viper.BindPFlag("tracing.file", validateTrieCmd.PersistentFlags().Lookup("tracing-file"))
+	viper.BindPFlag("tracing.storageThreshold", validateTrieCmd.PersistentFlags().Lookup("trace-storage-threshold"))
+	viper.BindPFlag("tracing.slowFetch", validateTrieCmd.PersistentFlags().Lookup("trace-slow-fetch"))
+	viper.BindPFlag("repair.from", validateTrieCmd.PersistentFlags().Lookup("repair-from"))
+	viper.BindPFlag("layered.report", validateTrieCmd.PersistentFlags().Lookup("layered-report"))
+	viper.BindPFlag("repair.log", validateTrieCmd.PersistentFlags().Lookup("repair-log"))
+	viper.BindPFlag("repair.blockNumber", validateTrieCmd.PersistentFlags().Lookup("repair-block-number"))
 }
@@ -0,0 +1,413 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	getBatchPgStr        = "SELECT key, data FROM ipld.blocks WHERE key = ANY($1)"
+	getBoundedBatchPgStr = "SELECT key, data FROM ipld.blocks WHERE key = ANY($1) AND block_number <= $2"
+)
+
+// BatchConfig configures a BatchedDatabase
+type BatchConfig struct {
+	BatchSize     int           // maximum keys fetched by one query; 1 disables batching
+	BatchWait     time.Duration // how long to wait for lookups to fill a batch before querying it
+	MaxConcurrent int           // maximum queries in flight
+	// Workers is the number of goroutines reading the database, so a batch is sent without waiting out BatchWait once
+	// all of them are waiting on lookups; NewBatchedValidator sets it to the validator's workers if unset
+	Workers uint
+
+	// Prefetch fetches the children of each branch and extension node as soon as it is fetched, in the same batches
+	// as the lookups of the workers, so the nodes are ready by the time the traversal descends to them
+	Prefetch    bool
+	MaxPrefetch int // maximum lookups queued, in flight or prefetched and not yet read, above which none are prefetched
+
+	MaxBlockNumber *uint64 // if set, only blocks written at or before it are read, as by a BoundedDatabase
+}
+
+// Defaults for unset BatchConfig fields
+const (
+	DefaultBatchSize     = 256
+	DefaultBatchWait     = time.Millisecond
+	DefaultBatchMaxConc  = 8
+	DefaultBatchPrefetch = 64 * 1024
+)
+
+// BatchedDatabase reads blocks from ipld.blocks, collecting the lookups made concurrently by the workers into one
+// WHERE key = ANY($1) query per batch, so a run costs a round trip per batch rather than per node.
+// SQLite databases from OpenSQLite are queried with an IN list instead.
+type BatchedDatabase struct {
+	ethdb.Database
+	db       *sqlx.DB
+	config   BatchConfig
+	requests chan *batchRequest
+	sem      chan struct{}
+	done     chan struct{}
+	full     chan struct{} // signalled when all the workers are waiting
+	workers  atomic.Int64  // Workers
+	active   atomic.Int64  // estimate of the workers still reading, which drops as they finish their subtries
+	waiting  atomic.Int64  // Get calls waiting on lookups
+
+	mu      sync.Mutex
+	pending map[string]*batchRequest // lookups queued, in flight or prefetched and not yet read, by CID
+}
+
+type batchRequest struct {
+	key   string // CID
+	codec uint64
+	value []byte
+	err   error
+	ready chan struct{}
+}
+
+// NewBatchedDatabase returns a database reading from db in batches
+func NewBatchedDatabase(db *sqlx.DB, config BatchConfig) *BatchedDatabase {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.BatchWait <= 0 {
+		config.BatchWait = DefaultBatchWait
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = DefaultBatchMaxConc
+	}
+	if config.MaxPrefetch <= 0 {
+		config.MaxPrefetch = DefaultBatchPrefetch
+	}
+	d := &BatchedDatabase{
+		db:       db,
+		config:   config,
+		requests: make(chan *batchRequest, config.BatchSize),
+		sem:      make(chan struct{}, config.MaxConcurrent),
+		done:     make(chan struct{}),
+		full:     make(chan struct{}, 1),
+		pending:  make(map[string]*batchRequest),
+	}
+	d.workers.Store(int64(config.Workers))
+	d.active.Store(int64(config.Workers))
+	go d.batchLoop()
+	return d
+}
+
+// NewBatchedValidator returns a new trie validator reading in batches
+func NewBatchedValidator(d *BatchedDatabase, par Params) *Validator {
+	if d.workers.CompareAndSwap(0, int64(par.Workers)) {
+		d.active.Store(int64(par.Workers))
+	}
+	backend := "postgres"
+	if d.db.DriverName() == "sqlite3" {
+		backend = "sqlite"
+	}
+	return newValidator(d, d, backend, par)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (d *BatchedDatabase) Get(key []byte) ([]byte, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return nil, err
+	}
+	req := d.lookup(c, true)
+	if n := d.waiting.Add(1); n > d.active.Load() && n <= d.workers.Load() {
+		d.active.Store(n)
+	}
+	if d.allWaiting() {
+		select {
+		case d.full <- struct{}{}:
+		default:
+		}
+	}
+	<-req.ready
+	d.waiting.Add(-1)
+	d.mu.Lock()
+	if d.pending[req.key] == req {
+		delete(d.pending, req.key)
+	}
+	d.mu.Unlock()
+	if req.err != nil {
+		return nil, req.err
+	}
+	return req.value, nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (d *BatchedDatabase) Has(key []byte) (bool, error) {
+	_, err := d.Get(key)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *BatchedDatabase) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *BatchedDatabase) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// Close stops the batching; lookups must have finished, and the underlying database is left open
+func (d *BatchedDatabase) Close() error {
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+	return nil
+}
+
+// Returns the pending lookup of the key, queueing one if there is none
+// Prefetches are not queued if too many lookups are pending already.
+func (d *BatchedDatabase) lookup(c cid.Cid, force bool) *batchRequest {
+	key := c.String()
+	select {
+	case <-d.done:
+		req := &batchRequest{key: key, codec: c.Type(), err: errDatabaseClosed, ready: make(chan struct{})}
+		close(req.ready)
+		return req
+	default:
+	}
+	d.mu.Lock()
+	req, ok := d.pending[key]
+	if ok || !force && len(d.pending) >= d.config.MaxPrefetch {
+		d.mu.Unlock()
+		return req
+	}
+	req = &batchRequest{key: key, codec: c.Type(), ready: make(chan struct{})}
+	d.pending[key] = req
+	d.mu.Unlock()
+
+	select {
+	case d.requests <- req:
+	case <-d.done:
+		req.err = errDatabaseClosed
+		close(req.ready)
+	}
+	return req
+}
+
+// Queues lookups of the child nodes referenced by hash from the trie nodes fetched by a batch
+func (d *BatchedDatabase) prefetch(batch []*batchRequest) {
+	for _, req := range batch {
+		if req.err != nil || req.codec != cid.EthStateTrie && req.codec != cid.EthStorageTrie {
+			continue
+		}
+		for _, hash := range childHashes(req.value) {
+			mh, err := multihash.Encode(hash, multihash.KECCAK_256)
+			if err != nil {
+				return
+			}
+			if d.lookup(cid.NewCidV1(req.codec, mh), false) == nil {
+				return
+			}
+		}
+	}
+}
+
+// Returns the hashes of the children a branch or extension node references by hash, rather than embeds
+func childHashes(blob []byte) [][]byte {
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return nil
+	}
+	n, err := rlp.CountValues(elems)
+	if err != nil {
+		return nil
+	}
+	var hashes [][]byte
+	switch n {
+	case 17: // branch
+		for i := 0; i < 16; i++ {
+			var kind rlp.Kind
+			var val []byte
+			if kind, val, elems, err = rlp.Split(elems); err != nil {
+				return hashes
+			}
+			if kind == rlp.String && len(val) == 32 {
+				hashes = append(hashes, val)
+			}
+		}
+	case 2: // extension or leaf
+		_, key, rest, err := rlp.Split(elems)
+		if err != nil || len(key) == 0 || key[0]>>4 >= 2 { // a leaf, by the hex-prefix flag of its key
+			return nil
+		}
+		if kind, val, _, err := rlp.Split(rest); err == nil && kind == rlp.String && len(val) == 32 {
+			hashes = append(hashes, val)
+		}
+	}
+	return hashes
+}
+
+// Collects queued lookups into batches, querying each once it is full, has waited BatchWait, or all the workers are
+// waiting; a batch is only started once a query can be sent, so lookups queue up while MaxConcurrent are in flight
+func (d *BatchedDatabase) batchLoop() {
+	for {
+		select {
+		case d.sem <- struct{}{}:
+		case <-d.done:
+			d.drain()
+			return
+		}
+		var batch []*batchRequest
+		select {
+		case req := <-d.requests:
+			batch = append(batch, req)
+		case <-d.done:
+			d.drain()
+			return
+		}
+		select {
+		case <-d.full: // stale
+		default:
+		}
+		timer := time.NewTimer(d.config.BatchWait)
+	collect:
+		for len(batch) < d.config.BatchSize && !d.allWaiting() {
+			select {
+			case req := <-d.requests:
+				batch = append(batch, req)
+			case <-timer.C:
+				// fewer workers are reading than were estimated; the next batches are sent once as many are waiting
+				if n := d.waiting.Load(); n > 0 {
+					d.active.Store(n)
+				}
+				break collect
+			case <-d.full:
+			case <-d.done:
+				break collect
+			}
+		}
+		timer.Stop()
+		d.collectQueued(&batch)
+		go func() {
+			d.query(batch)
+			<-d.sem
+			if d.config.Prefetch {
+				d.prefetch(batch)
+			}
+		}()
+	}
+}
+
+// Returns whether all the workers are waiting on lookups, so no more will be made until some are answered
+func (d *BatchedDatabase) allWaiting() bool {
+	active := d.active.Load()
+	return active > 0 && d.waiting.Load() >= active
+}
+
+// Adds the lookups already queued to the batch, up to its size
+func (d *BatchedDatabase) collectQueued(batch *[]*batchRequest) {
+	for len(*batch) < d.config.BatchSize {
+		select {
+		case req := <-d.requests:
+			*batch = append(*batch, req)
+		default:
+			return
+		}
+	}
+}
+
+// Fails the lookups still queued when the database is closed
+func (d *BatchedDatabase) drain() {
+	for {
+		select {
+		case req := <-d.requests:
+			req.err = errDatabaseClosed
+			close(req.ready)
+		default:
+			return
+		}
+	}
+}
+
+// Queries a batch of lookups; keys the database does not have fail with sql.ErrNoRows
+func (d *BatchedDatabase) query(batch []*batchRequest) {
+	keys := make([]string, len(batch))
+	for i, req := range batch {
+		keys[i] = req.key
+	}
+	values := make(map[string][]byte, len(batch))
+	err := d.queryKeys(keys, values)
+	for _, req := range batch {
+		if err != nil {
+			req.err = err
+		} else if value, ok := values[req.key]; ok {
+			req.value = value
+		} else {
+			req.err = fmt.Errorf("%s: %w", req.key, sql.ErrNoRows)
+		}
+		close(req.ready)
+	}
+}
+
+func (d *BatchedDatabase) queryKeys(keys []string, values map[string][]byte) error {
+	var query string
+	var args []interface{}
+	if d.db.DriverName() == "sqlite3" {
+		params := make([]string, len(keys))
+		for i, key := range keys {
+			params[i] = fmt.Sprintf("$%d", i+1)
+			args = append(args, key)
+		}
+		query = "SELECT key, data FROM ipld.blocks WHERE key IN (" + strings.Join(params, ", ") + ")"
+		if d.config.MaxBlockNumber != nil {
+			query += fmt.Sprintf(" AND block_number <= $%d", len(keys)+1)
+			args = append(args, *d.config.MaxBlockNumber)
+		}
+	} else {
+		query, args = getBatchPgStr, []interface{}{pq.Array(keys)}
+		if d.config.MaxBlockNumber != nil {
+			query, args = getBoundedBatchPgStr, append(args, *d.config.MaxBlockNumber)
+		}
+	}
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var data []byte
+		if err := rows.Scan(&key, &data); err != nil {
+			return err
+		}
+		values[key] = data
+	}
+	return rows.Err()
+}
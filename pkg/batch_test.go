@@ -0,0 +1,260 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("Batched lookups", func() {
+	var (
+		fixture  *validatortest.Fixture
+		sqlite   *sqlx.DB
+		database *validator.BatchedDatabase
+		params   validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_batch")
+		sqlite, err = validator.OpenSQLite(filepath.Join(tmp, "blocks.sqlite"), true)
+		Expect(err).ToNot(HaveOccurred())
+		fixture, err = validatortest.RandomFixture(rand.New(rand.NewSource(9)), 64, 8)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		if v != nil {
+			v.Close()
+		}
+		if database != nil {
+			database.Close()
+			database = nil
+		}
+		sqlite.Close()
+		os.RemoveAll(tmp)
+	})
+
+	for _, prefetch := range []bool{false, true} {
+		prefetch := prefetch
+		config := func() validator.BatchConfig {
+			return validator.BatchConfig{BatchSize: 16, Prefetch: prefetch}
+		}
+		It("Validates a complete trie", func() {
+			Expect(fixture.PublishDB(sqlite)).To(Succeed())
+			database = validator.NewBatchedDatabase(sqlite, config())
+			v = validator.NewBatchedValidator(database, params)
+			Expect(v.ValidateTrie(fixture.StateRoot)).To(Succeed())
+		})
+		It("Reports a missing storage node", func() {
+			var missing validatortest.Node
+			for _, n := range fixture.Nodes {
+				if n.Codec == cid.EthStorageTrie && len(n.Path) == 1 {
+					missing = n
+				}
+			}
+			Expect(missing.Data).ToNot(BeEmpty())
+			fixture.Delete(func(n validatortest.Node) bool { return n.Hash == missing.Hash })
+			Expect(fixture.PublishDB(sqlite)).To(Succeed())
+			database = validator.NewBatchedDatabase(sqlite, config())
+			v = validator.NewBatchedValidator(database, params)
+			err = v.ValidateTrie(fixture.StateRoot)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("missing trie node"))
+		})
+	}
+
+	It("Answers concurrent lookups of the same and different keys", func() {
+		Expect(fixture.PublishDB(sqlite)).To(Succeed())
+		database = validator.NewBatchedDatabase(sqlite, validator.BatchConfig{BatchSize: 8, BatchWait: 10 * time.Millisecond})
+		var wg sync.WaitGroup
+		for _, n := range fixture.Nodes {
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func(n validatortest.Node) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					Expect(database.Get(n.CID().Bytes())).To(Equal(n.Data))
+				}(n)
+			}
+		}
+		wg.Wait()
+		missing, err := validatortest.RawdataToCid(cid.EthStateTrie, []byte("missing"), multihash.KECCAK_256)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Has(missing.Bytes())).To(BeFalse())
+	})
+	It("Ignores nodes written after the bound", func() {
+		var late validatortest.Node
+		for _, n := range fixture.Nodes {
+			if n.Codec == cid.EthStateTrie && len(n.Path) == 1 {
+				late = n
+			}
+		}
+		fixture.Delete(func(n validatortest.Node) bool { return n.Hash == late.Hash })
+		fixture.BlockNumber = 5
+		Expect(fixture.PublishDB(sqlite)).To(Succeed())
+		tx, err := sqlite.Beginx()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(validatortest.PublishRaw(tx, late.Codec, multihash.KECCAK_256, late.Data, 9)).To(Succeed())
+		Expect(tx.Commit()).To(Succeed())
+
+		before, after := uint64(5), uint64(9)
+		database = validator.NewBatchedDatabase(sqlite, validator.BatchConfig{Prefetch: true, MaxBlockNumber: &before})
+		v = validator.NewBatchedValidator(database, params)
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+		v.Close()
+		database.Close()
+		database = validator.NewBatchedDatabase(sqlite, validator.BatchConfig{Prefetch: true, MaxBlockNumber: &after})
+		v = validator.NewBatchedValidator(database, params)
+		Expect(v.ValidateTrie(fixture.StateRoot)).To(Succeed())
+	})
+	It("Applies a bound of block 0", func() {
+		fixture.BlockNumber = 1
+		Expect(fixture.PublishDB(sqlite)).To(Succeed())
+		database = validator.NewBatchedDatabase(sqlite, validator.BatchConfig{MaxBlockNumber: new(uint64)})
+		Expect(database.Has(fixture.Nodes[0].CID().Bytes())).To(BeFalse())
+	})
+	It("Fails lookups once closed", func() {
+		Expect(fixture.PublishDB(sqlite)).To(Succeed())
+		database = validator.NewBatchedDatabase(sqlite, validator.BatchConfig{})
+		Expect(database.Close()).To(Succeed())
+		_, err := database.Get(fixture.Nodes[0].CID().Bytes())
+		Expect(err).To(MatchError(ContainSubstring("closed")))
+	})
+})
+
+// Compares full validations through per-key lookups and through batched lookups, with and without prefetching, on
+// Postgres if the test database is available and on SQLite. Batching pays off with the round-trip time to the
+// database, so the gap is widest with Postgres over a network; SQLite has no round trips and shows its overhead.
+//
+//	go test ./pkg -run '^$' -bench BatchedLookups
+func BenchmarkBatchedLookups(b *testing.B) {
+	fixture, err := validatortest.RandomFixture(rand.New(rand.NewSource(10)), 1024, 16)
+	if err != nil {
+		b.Fatal(err)
+	}
+	params := validator.Params{Workers: 8, RecoveryFormat: filepath.Join(b.TempDir(), "recover_%s")}
+
+	b.Run("postgres", func(b *testing.B) {
+		db, err := openTestDB()
+		if err != nil {
+			b.Skipf("Postgres test database is unavailable: %v", err)
+		}
+		defer db.Close()
+		defer ResetTestDB(db)
+		if err := fixture.PublishDB(db); err != nil {
+			b.Fatal(err)
+		}
+		benchmarkBatchedLookups(b, db, fixture, func() *validator.Validator { return validator.NewPGIPFSValidator(db, params) }, params)
+	})
+	// SQLite with a delay added to each query stands in for a database across a network
+	for _, latency := range []time.Duration{0, 500 * time.Microsecond} {
+		b.Run(fmt.Sprintf("sqlite+%s", latency), func(b *testing.B) {
+			sqlite, err := validator.OpenSQLite(filepath.Join(b.TempDir(), "blocks.sqlite"), true)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer sqlite.Close()
+			if err := fixture.PublishDB(sqlite); err != nil {
+				b.Fatal(err)
+			}
+			db := sqlx.NewDb(sql.OpenDB(&delayedConnector{sqlite.Driver(), latency}), "sqlite3")
+			defer db.Close()
+			benchmarkBatchedLookups(b, db, fixture, func() *validator.Validator { return validator.NewSQLiteValidator(db, params) }, params)
+		})
+	}
+}
+
+// delayedConnector opens connections of a database opened with OpenSQLite, delaying each query
+type delayedConnector struct {
+	driver driver.Driver
+	delay  time.Duration
+}
+
+func (c *delayedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return &delayedConn{conn.(*sqlite3.SQLiteConn), c.delay}, nil
+}
+
+func (c *delayedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type delayedConn struct {
+	*sqlite3.SQLiteConn
+	delay time.Duration
+}
+
+func (c *delayedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	time.Sleep(c.delay)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func benchmarkBatchedLookups(b *testing.B, db *sqlx.DB, fixture *validatortest.Fixture, perKey func() *validator.Validator, params validator.Params) {
+	for _, bench := range []struct {
+		name     string
+		batched  bool
+		prefetch bool
+	}{
+		{"per-key", false, false},
+		{"batched", true, false},
+		{"batched+prefetch", true, true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var v *validator.Validator
+				var database *validator.BatchedDatabase
+				if bench.batched {
+					database = validator.NewBatchedDatabase(db, validator.BatchConfig{Prefetch: bench.prefetch})
+					v = validator.NewBatchedValidator(database, params)
+				} else {
+					v = perKey()
+				}
+				if err := v.ValidateTrie(fixture.StateRoot); err != nil {
+					b.Fatal(err)
+				}
+				v.Close()
+				if database != nil {
+					database.Close()
+				}
+			}
+			b.ReportMetric(float64(len(fixture.Nodes))*float64(b.N)/b.Elapsed().Seconds(), "nodes/s")
+		})
+	}
+}
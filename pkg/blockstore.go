@@ -0,0 +1,126 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+)
+
+var errReadOnly = errors.New("read-only database")
+
+// BlockGetter is the subset of a blockstore needed to read nodes by CID
+type BlockGetter interface {
+	Get(context.Context, cid.Cid) (blocks.Block, error)
+	Has(context.Context, cid.Cid) (bool, error)
+}
+
+// BlockPutter is implemented by blockstores which can be written to
+type BlockPutter interface {
+	Put(context.Context, blocks.Block) error
+}
+
+// BlockstoreDatabase is an ethdb.Database over a blockstore, keyed by CID
+// Only the key-value reader and writer methods are implemented; it is read-only unless the blockstore is a BlockPutter
+type BlockstoreDatabase struct {
+	ethdb.Database
+	bs BlockGetter
+}
+
+// NewBlockstoreDatabase returns a database reading blocks from the blockstore
+func NewBlockstoreDatabase(bs BlockGetter) *BlockstoreDatabase {
+	return &BlockstoreDatabase{bs: bs}
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (d *BlockstoreDatabase) Get(key []byte) ([]byte, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := d.bs.Get(context.Background(), c)
+	if err != nil {
+		return nil, err
+	}
+	return block.RawData(), nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (d *BlockstoreDatabase) Has(key []byte) (bool, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return false, err
+	}
+	return d.bs.Has(context.Background(), c)
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *BlockstoreDatabase) Put(key []byte, value []byte) error {
+	putter, ok := d.bs.(BlockPutter)
+	if !ok {
+		return errReadOnly
+	}
+	c, err := cid.Cast(key)
+	if err != nil {
+		return err
+	}
+	block, err := blocks.NewBlockWithCid(value, c)
+	if err != nil {
+		return err
+	}
+	return putter.Put(context.Background(), block)
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *BlockstoreDatabase) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// Close satisfies the io.Closer interface
+// The blockstore is closed if it can be
+func (d *BlockstoreDatabase) Close() error {
+	if closer, ok := d.bs.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// BlockServiceStore reads and writes blocks through a blockservice
+type BlockServiceStore struct {
+	blockservice.BlockService
+}
+
+// Get satisfies the BlockGetter interface
+func (b BlockServiceStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return b.GetBlock(ctx, c)
+}
+
+// Has satisfies the BlockGetter interface
+func (b BlockServiceStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return b.Blockstore().Has(ctx, c)
+}
+
+// Put satisfies the BlockPutter interface
+func (b BlockServiceStore) Put(ctx context.Context, block blocks.Block) error {
+	return b.AddBlock(ctx, block)
+}
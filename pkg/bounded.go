@@ -0,0 +1,87 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	getBoundedBlockPgStr = "SELECT data FROM ipld.blocks WHERE key = $1 AND block_number <= $2 LIMIT 1"
+	hasBoundedBlockPgStr = "SELECT exists(SELECT 1 FROM ipld.blocks WHERE key = $1 AND block_number <= $2)"
+)
+
+// BoundedDatabase reads blocks from ipld.blocks which were written at or before a block number, so the lookups of a
+// partitioned table are pruned to the partitions up to it, and a validation checks the state is complete as of that
+// block, ignoring nodes written later
+type BoundedDatabase struct {
+	ethdb.Database
+	db             *sqlx.DB
+	MaxBlockNumber uint64
+}
+
+// NewBoundedDatabase returns a database reading blocks written at or before the block number
+func NewBoundedDatabase(db *sqlx.DB, maxBlockNumber uint64) *BoundedDatabase {
+	return &BoundedDatabase{db: db, MaxBlockNumber: maxBlockNumber}
+}
+
+// NewBoundedValidator returns a new trie validator reading blocks written at or before the block number
+func NewBoundedValidator(db *sqlx.DB, maxBlockNumber uint64, par Params) *Validator {
+	database := NewBoundedDatabase(db, maxBlockNumber)
+	return newValidator(database, database, "postgres", par)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (d *BoundedDatabase) Get(key []byte) ([]byte, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := d.db.Get(&data, getBoundedBlockPgStr, c.String(), d.MaxBlockNumber); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (d *BoundedDatabase) Has(key []byte) (bool, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = d.db.Get(&exists, hasBoundedBlockPgStr, c.String(), d.MaxBlockNumber)
+	return exists, err
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *BoundedDatabase) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *BoundedDatabase) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// Close satisfies the io.Closer interface
+func (d *BoundedDatabase) Close() error {
+	return nil
+}
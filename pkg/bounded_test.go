@@ -0,0 +1,141 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("Block-number-bounded lookups", func() {
+	var (
+		fixture *validatortest.Fixture
+		sqlite  *sqlx.DB
+		params  validator.Params
+	)
+
+	// Publishes the fixture at block 5, except for a state node which is only written at block 9
+	BeforeEach(func() {
+		params = makeTmp("test_bounded")
+		sqlite, err = validator.OpenSQLite(filepath.Join(tmp, "blocks.sqlite"), true)
+		Expect(err).ToNot(HaveOccurred())
+		fixture, err = validatortest.RandomFixture(rand.New(rand.NewSource(7)), 32, 8)
+		Expect(err).ToNot(HaveOccurred())
+		var late validatortest.Node
+		for _, n := range fixture.Nodes {
+			if n.Codec == cid.EthStateTrie && len(n.Path) == 1 {
+				late = n
+			}
+		}
+		Expect(fixture.Delete(func(n validatortest.Node) bool { return n.Hash == late.Hash })).ToNot(BeEmpty())
+		fixture.BlockNumber = 5
+		Expect(fixture.PublishDB(sqlite)).To(Succeed())
+		tx, err := sqlite.Beginx()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(validatortest.PublishRaw(tx, late.Codec, multihash.KECCAK_256, late.Data, 9)).To(Succeed())
+		Expect(tx.Commit()).To(Succeed())
+	})
+	AfterEach(func() {
+		if v != nil {
+			v.Close()
+		}
+		sqlite.Close()
+		os.RemoveAll(tmp)
+	})
+
+	It("Ignores nodes written after the bound", func() {
+		v = validator.NewBoundedValidator(sqlite, 5, params)
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+	})
+	It("Reads nodes written at or before the bound", func() {
+		v = validator.NewBoundedValidator(sqlite, 9, params)
+		Expect(v.ValidateTrie(fixture.StateRoot)).To(Succeed())
+	})
+	It("Answers Has within the bound", func() {
+		database := validator.NewBoundedDatabase(sqlite, 5)
+		for _, n := range fixture.Nodes {
+			Expect(database.Has(n.CID().Bytes())).To(BeTrue())
+		}
+	})
+})
+
+// Compares full validations of a root through the unbounded pgipfsethdb lookups and through lookups bounded at the
+// root's block, with later blocks written after it. The gap depends on ipld.blocks being partitioned, as it is in
+// ipld-eth-db v5 with TimescaleDB, and grows with the number of chunks after the bound.
+//
+//	go test ./pkg -run '^$' -bench BoundedLookups
+func BenchmarkBoundedLookups(b *testing.B) {
+	db, err := openTestDB()
+	if err != nil {
+		b.Skipf("Postgres test database is unavailable: %v", err)
+	}
+	defer db.Close()
+	defer ResetTestDB(db)
+
+	rng := rand.New(rand.NewSource(8))
+	fixture, err := validatortest.RandomFixture(rng, 256, 16)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fixture.BlockNumber = 1
+	if err := fixture.PublishDB(db); err != nil {
+		b.Fatal(err)
+	}
+	for block := uint64(2); block <= 64; block++ {
+		later, err := validatortest.RandomFixture(rng, 256, 16)
+		if err != nil {
+			b.Fatal(err)
+		}
+		later.BlockNumber = block
+		if err := later.PublishDB(db); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	params := validator.Params{Workers: 4, RecoveryFormat: filepath.Join(b.TempDir(), "recover_%s")}
+	for _, bench := range []struct {
+		name string
+		open func() *validator.Validator
+	}{
+		{"pgipfsethdb", func() *validator.Validator { return validator.NewPGIPFSValidator(db, params) }},
+		{"bounded", func() *validator.Validator { return validator.NewBoundedValidator(db, fixture.BlockNumber, params) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				v := bench.open()
+				if err := v.ValidateTrie(fixture.StateRoot); err != nil {
+					b.Fatal(err)
+				}
+				v.Close()
+			}
+			b.ReportMetric(float64(len(fixture.Nodes)), "nodes/op")
+		})
+	}
+}
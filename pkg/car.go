@@ -0,0 +1,60 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-cid"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+)
+
+// OpenCAR opens a CARv1 or CARv2 file as a read-only blockstore
+// CARv2 files are read through their index; CARv1 files, or CARv2 files without one, are indexed in memory
+func OpenCAR(path string) (*carblockstore.ReadOnly, error) {
+	return carblockstore.OpenReadOnly(path)
+}
+
+// NewCARValidator returns a new trie validator ontop of a CAR file's blocks
+func NewCARValidator(car *carblockstore.ReadOnly, par Params) *Validator {
+	database := NewBlockstoreDatabase(car)
+	return newValidator(database, database, "car", par)
+}
+
+// StateRootCID returns the CID of a state root node
+func StateRootCID(stateRoot common.Hash) (cid.Cid, error) {
+	return keccak256ToCid(cid.EthStateTrie, stateRoot.Bytes())
+}
+
+// CARHasRoot returns whether the state root's CID is among the roots listed in the CAR header
+func CARHasRoot(car *carblockstore.ReadOnly, stateRoot common.Hash) (bool, error) {
+	roots, err := car.Roots()
+	if err != nil {
+		return false, err
+	}
+	c, err := StateRootCID(stateRoot)
+	if err != nil {
+		return false, err
+	}
+	for _, root := range roots {
+		if bytes.Equal(root.Hash(), c.Hash()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
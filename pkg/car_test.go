@@ -0,0 +1,91 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-cid"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("CAR validator", func() {
+	var (
+		car    *carblockstore.ReadOnly
+		params validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_car")
+	})
+	AfterEach(func() {
+		if car != nil {
+			car.Close()
+		}
+		os.RemoveAll(tmp)
+	})
+
+	openCAR := func(v1 bool, stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+		path := filepath.Join(tmp, "state.car")
+		writeCAR(path, v1, stateNodes, storageNodes, contractCode...)
+		car, err = validator.OpenCAR(path)
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewCARValidator(car, params)
+	}
+
+	for _, version := range []string{"CARv1", "CARv2"} {
+		v1 := version == "CARv1"
+		Describe(version, func() {
+			itValidatesStorageTries(func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+				openCAR(v1, stateNodes, storageNodes, contractCode...)
+			})
+			It("Returns an error if contract code is missing", func() {
+				openCAR(v1, trieStateNodes, trieStorageNodes)
+				err = v.ValidateTrie(stateRoot)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("code hash"))
+			})
+			It("Checks the roots listed in the header", func() {
+				openCAR(v1, trieStateNodes, trieStorageNodes, mockCode)
+				ok, err := validator.CARHasRoot(car, stateRoot)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ok).To(BeTrue())
+				ok, err = validator.CARHasRoot(car, common.Hash{1})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ok).To(BeFalse())
+			})
+		})
+	}
+})
+
+// Writes the nodes to a CAR file rooted at the state root
+func writeCAR(path string, v1 bool, stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+	root, err := validatortest.RawdataToCid(cid.EthStateTrie, trieStateNodes[0], multihash.KECCAK_256)
+	Expect(err).ToNot(HaveOccurred())
+	writer, err := carblockstore.OpenReadWrite(path, []cid.Cid{root}, carblockstore.WriteAsCarV1(v1))
+	Expect(err).ToNot(HaveOccurred())
+	putTrie(writer, stateNodes, storageNodes, contractCode...)
+	Expect(writer.Finalize()).To(Succeed())
+}
@@ -0,0 +1,117 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+)
+
+// ChaindataConfig locates a geth chaindata directory
+type ChaindataConfig struct {
+	Directory string // the chaindata directory, e.g. ~/.ethereum/geth/chaindata
+	Ancient   string // the freezer directory; defaults to <Directory>/ancient if that exists
+	Type      string // "leveldb" or "pebble"; detected from the directory if empty
+	Cache     int    // cache size in megabytes
+	Handles   int    // number of open files
+	Writable  bool   // open for writing, e.g. as the destination of a copy; read-only otherwise
+}
+
+// OpenChaindata opens a geth LevelDB or Pebble database, read-only unless configured otherwise, with the freezer attached
+func OpenChaindata(c ChaindataConfig) (ethdb.Database, error) {
+	ancient := c.Ancient
+	if ancient == "" {
+		if _, err := os.Stat(filepath.Join(c.Directory, "ancient")); err == nil {
+			ancient = filepath.Join(c.Directory, "ancient")
+		}
+	}
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:              c.Type,
+		Directory:         c.Directory,
+		AncientsDirectory: ancient,
+		Namespace:         "validator/chaindata/",
+		Cache:             c.Cache,
+		Handles:           c.Handles,
+		ReadOnly:          !c.Writable,
+	})
+}
+
+// NewChaindataValidator returns a new trie validator ontop of a geth chaindata database
+// Nodes are looked up by hash, as stored by geth's hash-based state scheme
+func NewChaindataValidator(db ethdb.Database, par Params) *Validator {
+	database := NewChaindataDatabase(db)
+	return newValidator(database, database, "chaindata", par)
+}
+
+// ChaindataDatabase adapts a hash-keyed geth database to the CID keyed lookups made by the validator
+type ChaindataDatabase struct {
+	ethdb.Database
+}
+
+// NewChaindataDatabase wraps a geth database so it can be read by CID
+func NewChaindataDatabase(db ethdb.Database) *ChaindataDatabase {
+	return &ChaindataDatabase{Database: db}
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// The key is a CID; trie nodes are read by their hash, and code by its code hash
+func (d *ChaindataDatabase) Get(key []byte) ([]byte, error) {
+	c, hash, err := keyToHash(key)
+	if err != nil {
+		return nil, err
+	}
+	var val []byte
+	if c.Type() == cid.Raw {
+		val = rawdb.ReadCode(d.Database, hash)
+	} else {
+		val = rawdb.ReadLegacyTrieNode(d.Database, hash)
+	}
+	if len(val) == 0 {
+		return nil, fmt.Errorf("%s: %w", c, ErrNotFound)
+	}
+	return val, nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (d *ChaindataDatabase) Has(key []byte) (bool, error) {
+	c, hash, err := keyToHash(key)
+	if err != nil {
+		return false, err
+	}
+	if c.Type() == cid.Raw {
+		return rawdb.HasCode(d.Database, hash), nil
+	}
+	return rawdb.HasLegacyTrieNode(d.Database, hash), nil
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Trie nodes are written under their hash and code under its code hash, as geth's hash-based state scheme does
+func (d *ChaindataDatabase) Put(key []byte, value []byte) error {
+	c, hash, err := keyToHash(key)
+	if err != nil {
+		return err
+	}
+	if c.Type() == cid.Raw {
+		return d.Database.Put(append(rawdb.CodePrefix, hash.Bytes()...), value)
+	}
+	return d.Database.Put(hash.Bytes(), value)
+}
@@ -0,0 +1,96 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var _ = Describe("Chaindata validator", func() {
+	var (
+		chaindata ethdb.Database
+		params    validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_chaindata")
+	})
+	AfterEach(func() {
+		if chaindata != nil {
+			chaindata.Close()
+		}
+		os.RemoveAll(tmp)
+	})
+
+	// Writes the nodes to a new chaindata directory with a freezer, then reopens it read-only
+	writeChaindata := func(dbType string, stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+		dir := filepath.Join(tmp, "chaindata")
+		writer, err := rawdb.Open(rawdb.OpenOptions{
+			Type:              dbType,
+			Directory:         dir,
+			AncientsDirectory: filepath.Join(dir, "ancient"),
+			Cache:             16,
+			Handles:           16,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for _, node := range append(stateNodes, storageNodes...) {
+			rawdb.WriteLegacyTrieNode(writer, crypto.Keccak256Hash(node), node)
+		}
+		for _, code := range contractCode {
+			rawdb.WriteCode(writer, crypto.Keccak256Hash(code), code)
+		}
+		Expect(writer.Close()).To(Succeed())
+
+		chaindata, err = validator.OpenChaindata(validator.ChaindataConfig{Directory: dir, Cache: 16, Handles: 16})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = chaindata.Ancients()
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewChaindataValidator(chaindata, params)
+	}
+
+	for _, dbType := range []string{"leveldb", "pebble"} {
+		dbType := dbType
+		Describe(dbType, func() {
+			It("Returns no error if the entire state can be validated", func() {
+				writeChaindata(dbType, trieStateNodes, trieStorageNodes, mockCode)
+				err = v.ValidateTrie(stateRoot)
+				Expect(err).ToNot(HaveOccurred())
+			})
+			It("Returns an error if the state trie is missing node(s)", func() {
+				writeChaindata(dbType, missingNodeStateNodes, trieStorageNodes, mockCode)
+				err = v.ValidateTrie(stateRoot)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("missing trie node"))
+			})
+			It("Returns an error if contract code is missing", func() {
+				writeChaindata(dbType, trieStateNodes, trieStorageNodes)
+				err = v.ValidateTrie(stateRoot)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("code hash"))
+			})
+		})
+	}
+})
@@ -0,0 +1,66 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// Returns the CID of a node or code blob with the given codec from its keccak256 hash
+func keccak256ToCid(codec uint64, hash []byte) (cid.Cid, error) {
+	mh, err := multihash.Encode(hash, multihash.KECCAK_256)
+	if err != nil {
+		return cid.Cid{}, err
+	}
+	return cid.NewCidV1(codec, mh), nil
+}
+
+// Returns the keccak256 hash a CID key was derived from
+func keyToHash(key []byte) (cid.Cid, common.Hash, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return cid.Cid{}, common.Hash{}, err
+	}
+	mh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return cid.Cid{}, common.Hash{}, err
+	}
+	if mh.Code != multihash.KECCAK_256 {
+		return cid.Cid{}, common.Hash{}, fmt.Errorf("unexpected multihash %s for key %s", mh.Name, c)
+	}
+	return c, common.BytesToHash(mh.Digest), nil
+}
+
+// Returns an error if a value read under a CID key does not hash to it, so that corrupt nodes are reported rather than decoded
+func verifyValue(key, value []byte) error {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return nil // not keyed by CID
+	}
+	got, err := c.Prefix().Sum(value)
+	if err != nil {
+		return err
+	}
+	if !got.Equals(c) {
+		return fmt.Errorf("value read for %s has CID %s", c, got)
+	}
+	return nil
+}
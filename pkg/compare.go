@@ -0,0 +1,215 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/sync/errgroup"
+
+	iterutils "github.com/cerc-io/eth-iterator-utils"
+)
+
+// Difference is the way a node differs between the two backends of a comparison
+type Difference string
+
+const (
+	OnlyInA      Difference = "only-a"       // the node is missing from backend B
+	OnlyInB      Difference = "only-b"       // the node is missing from backend A
+	ValuesDiffer Difference = "differ"       // both backends have the node, with different bytes
+	MissingBoth  Difference = "missing-both" // neither backend has the node; its subtrie is not compared
+)
+
+// missingNode is served in place of a node missing from both backends: an empty branch node, so the traversal visits
+// the node and skips its subtrie
+var missingNode = append([]byte{0xd1}, bytes.Repeat([]byte{0x80}, 17)...)
+
+// NodeDifference is a node which differs between the two backends of a comparison
+type NodeDifference struct {
+	Difference  Difference
+	CID         cid.Cid
+	Kind        string // state, storage or code
+	StatePath   []byte // nibbles of the state node, or of the account a storage node or code blob belongs to
+	StoragePath []byte // nibbles of a storage node in its trie
+	A, B        []byte // the values read from each backend; nil if missing
+}
+
+// CompareStats summarizes a comparison
+type CompareStats struct {
+	Nodes       uint64 // state and storage nodes compared
+	Code        uint64 // code blobs compared
+	Differences uint64
+}
+
+// CompareState traverses the state and storage tries and contract code for the state root in two backends at once,
+// both keyed by CID, and calls report with every node missing from one of them or whose bytes differ between them.
+// The traversal follows the nodes of either backend, so the subtries below a node only one of them has are compared
+// too. A node reached at more than one path, such as code or a storage trie shared between accounts, is reported once,
+// at the first path it is reached at. Nodes missing from both backends are reported without their subtries; nodes
+// corrupt in both fail the comparison.
+func CompareState(stateRoot common.Hash, a, b ethdb.KeyValueReader, par Params, report func(NodeDifference) error) (CompareStats, error) {
+	var stats CompareStats
+	db := &comparingDatabase{a: a, b: b, differences: make(map[string]NodeDifference), reported: make(map[string]bool)}
+	v := newValidator(db, db, "compare", par)
+	defer v.Close()
+	t, err := v.stateDatabase.OpenTrie(stateRoot)
+	if err != nil {
+		return stats, err
+	}
+
+	var mu sync.Mutex
+	var nodes, code, differences atomic.Uint64
+	visit := func(codec uint64, hash common.Hash, _ []byte, statePath, storagePath []byte) error {
+		if codec == cid.Raw {
+			code.Add(1)
+		} else {
+			nodes.Add(1)
+		}
+		c, err := keccak256ToCid(codec, hash.Bytes())
+		if err != nil {
+			return err
+		}
+		diff, ok := db.take(c.Bytes())
+		if !ok {
+			return nil
+		}
+		differences.Add(1)
+		diff.StatePath = append([]byte(nil), statePath...)
+		diff.StoragePath = append([]byte(nil), storagePath...)
+		mu.Lock()
+		defer mu.Unlock()
+		return report(diff)
+	}
+
+	v.progress = newProgress()
+	stopReporting := v.progress.start(v.params.ProgressInterval, v.params.ProgressBar)
+	g, ctx := errgroup.WithContext(context.Background())
+	for _, it := range iterutils.SubtrieIterators(t.NodeIterator, v.params.Workers) {
+		it := v.progress.trackWorker(it)
+		g.Go(func() error { return v.iterate(ctx, it, true, visit) })
+	}
+	err = g.Wait()
+	stopReporting()
+	stats.Nodes, stats.Code, stats.Differences = nodes.Load(), code.Load(), differences.Load()
+	return stats, err
+}
+
+// comparingDatabase reads each key from two backends in parallel, recording how they differ, and serves whichever
+// value matches the key's CID
+type comparingDatabase struct {
+	ethdb.Database
+	a, b ethdb.KeyValueReader
+
+	mu          sync.Mutex
+	differences map[string]NodeDifference // by key, until the traversal visits them
+	reported    map[string]bool           // keys whose difference the traversal has visited
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (d *comparingDatabase) Get(key []byte) ([]byte, error) {
+	var (
+		wg         sync.WaitGroup
+		va, vb     []byte
+		erra, errb error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		va, erra = d.a.Get(key)
+	}()
+	vb, errb = d.b.Get(key)
+	wg.Wait()
+	if erra != nil && !isNotFound(erra) {
+		return nil, fmt.Errorf("backend a: %w", erra)
+	}
+	if errb != nil && !isNotFound(errb) {
+		return nil, fmt.Errorf("backend b: %w", errb)
+	}
+	inA, inB := erra == nil, errb == nil
+
+	var diff Difference
+	switch {
+	case inA && inB && bytes.Equal(va, vb):
+		return va, nil
+	case !inA && !inB:
+		diff = MissingBoth
+	case !inB:
+		diff = OnlyInA
+	case !inA:
+		diff = OnlyInB
+	default:
+		diff = ValuesDiffer
+	}
+	c, _ := cid.Cast(key)
+	d.mu.Lock()
+	// a node shared between subtries is read again at each path, but only reported at the first
+	if !d.reported[string(key)] {
+		d.differences[string(key)] = NodeDifference{Difference: diff, CID: c, Kind: nodeKind(key), A: va, B: vb}
+	}
+	d.mu.Unlock()
+
+	// continue the traversal below the node with a value that is valid, if either backend has one
+	if diff == MissingBoth {
+		return missingNode, nil
+	}
+	if inA && verifyValue(key, va) == nil || !inB {
+		return va, nil
+	}
+	return vb, nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (d *comparingDatabase) Has(key []byte) (bool, error) {
+	if has, err := d.a.Has(key); err != nil || has {
+		return has, err
+	}
+	return d.b.Has(key)
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *comparingDatabase) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *comparingDatabase) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// Close satisfies the io.Closer interface
+func (d *comparingDatabase) Close() error {
+	return nil
+}
+
+// Removes and returns the difference recorded for a key, if any, marking it reported
+func (d *comparingDatabase) take(key []byte) (NodeDifference, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	diff, ok := d.differences[string(key)]
+	if ok {
+		delete(d.differences, string(key))
+		d.reported[string(key)] = true
+	}
+	return diff, ok
+}
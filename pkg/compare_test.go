@@ -0,0 +1,167 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	iterutils "github.com/cerc-io/eth-iterator-utils"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("Backend comparison", func() {
+	var (
+		fixture                          *validatortest.Fixture
+		a, b                             ethdb.KeyValueStore
+		stateNode, storageNode, codeNode validatortest.Node
+		params                           = validator.Params{Workers: 4}
+	)
+
+	// Publishes the fixture to both stores, then removes a state node and code from b and corrupts a storage node in it
+	BeforeEach(func() {
+		fixture, err = validatortest.RandomFixture(rand.New(rand.NewSource(4)), 32, 8)
+		Expect(err).ToNot(HaveOccurred())
+		a, b = validatortest.NewMemoryStore(), validatortest.NewMemoryStore()
+		Expect(fixture.Publish(a)).To(Succeed())
+		Expect(fixture.Publish(b)).To(Succeed())
+
+		for _, n := range fixture.Nodes {
+			switch {
+			case n.Codec == cid.EthStateTrie && string(n.Path) == "\x09":
+				stateNode = n
+			case n.Codec == cid.EthStorageTrie && len(n.Path) == 1:
+				storageNode = n
+			case n.Codec == cid.Raw:
+				codeNode = n
+			}
+		}
+		Expect(stateNode.Data).ToNot(BeNil())
+		Expect(storageNode.Data).ToNot(BeNil())
+		Expect(codeNode.Data).ToNot(BeNil())
+		Expect(b.Delete(stateNode.CID().Bytes())).To(Succeed())
+		Expect(b.Delete(codeNode.CID().Bytes())).To(Succeed())
+		Expect(b.Put(storageNode.CID().Bytes(), append([]byte{0}, storageNode.Data...))).To(Succeed())
+	})
+
+	compare := func(a, b ethdb.KeyValueReader) (validator.CompareStats, map[cid.Cid]validator.NodeDifference, error) {
+		diffs := make(map[cid.Cid]validator.NodeDifference)
+		stats, err := validator.CompareState(fixture.StateRoot, a, b, params, func(diff validator.NodeDifference) error {
+			defer GinkgoRecover() // report is called from the traversal's workers
+			Expect(diffs).ToNot(HaveKey(diff.CID))
+			diffs[diff.CID] = diff
+			return nil
+		})
+		return stats, diffs, err
+	}
+
+	It("Reports nodes missing from one backend or differing, with their paths", func() {
+		stats, diffs, err := compare(a, b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Differences).To(Equal(uint64(3)))
+		Expect(diffs).To(HaveLen(3))
+		// the subtrie below the missing state node is still compared
+		Expect(stats.Nodes + stats.Code).To(BeNumerically(">=", len(fixture.Nodes)))
+
+		state := diffs[stateNode.CID()]
+		Expect(state.Difference).To(Equal(validator.OnlyInA))
+		Expect(state.Kind).To(Equal("state"))
+		Expect(state.StatePath).To(Equal(stateNode.Path))
+		Expect(state.B).To(BeNil())
+
+		code := diffs[codeNode.CID()]
+		Expect(code.Difference).To(Equal(validator.OnlyInA))
+		Expect(code.Kind).To(Equal("code"))
+		Expect(code.StatePath).ToNot(BeEmpty())
+
+		storage := diffs[storageNode.CID()]
+		Expect(storage.Difference).To(Equal(validator.ValuesDiffer))
+		Expect(storage.Kind).To(Equal("storage"))
+		Expect(iterutils.HexToKeyBytes(storage.StatePath)).To(Equal(storageNode.Owner.Bytes()))
+		Expect(storage.StoragePath).To(Equal(storageNode.Path))
+		Expect(storage.A).To(Equal(storageNode.Data))
+		Expect(storage.B).ToNot(Equal(storageNode.Data))
+	})
+	It("Reports nodes only the second backend has", func() {
+		stats, diffs, err := compare(b, a)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Differences).To(Equal(uint64(3)))
+		Expect(diffs[stateNode.CID()].Difference).To(Equal(validator.OnlyInB))
+		Expect(diffs[codeNode.CID()].Difference).To(Equal(validator.OnlyInB))
+		Expect(diffs[storageNode.CID()].Difference).To(Equal(validator.ValuesDiffer))
+	})
+	It("Reports no differences between identical backends", func() {
+		stats, diffs, err := compare(a, a)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(diffs).To(BeEmpty())
+		Expect(stats.Nodes + stats.Code).To(BeNumerically(">=", len(fixture.Nodes)))
+	})
+	It("Reports nodes missing from both backends without their subtries", func() {
+		full, _, err := compare(a, a)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.Delete(stateNode.CID().Bytes())).To(Succeed())
+		stats, diffs, err := compare(a, b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Nodes).To(BeNumerically("<", full.Nodes))
+		missing := diffs[stateNode.CID()]
+		Expect(missing.Difference).To(Equal(validator.MissingBoth))
+		Expect(missing.Kind).To(Equal("state"))
+		Expect(missing.StatePath).To(Equal(stateNode.Path))
+		Expect(missing.A).To(BeNil())
+		Expect(missing.B).To(BeNil())
+		// nothing below the missing node is reached
+		for c, diff := range diffs {
+			if c != stateNode.CID() {
+				Expect(bytes.HasPrefix(diff.StatePath, stateNode.Path)).To(BeFalse())
+			}
+		}
+	})
+	It("Reports a node shared between accounts once", func() {
+		rng := rand.New(rand.NewSource(4))
+		accounts := validatortest.RandomAccounts(rng, 8, 4)
+		shared := make(map[common.Hash]common.Hash)
+		for len(shared) < 32 {
+			var slot common.Hash
+			rng.Read(slot[:])
+			shared[slot] = common.BigToHash(big.NewInt(rng.Int63()))
+		}
+		accounts[0].Storage, accounts[1].Storage = shared, shared
+		fixture, err = validatortest.NewFixture(accounts...)
+		Expect(err).ToNot(HaveOccurred())
+		a, b = validatortest.NewMemoryStore(), validatortest.NewMemoryStore()
+		Expect(fixture.Publish(a)).To(Succeed())
+		Expect(fixture.Publish(b)).To(Succeed())
+		deleted, ok := fixture.DeleteStorageNode(accounts[0].Address, []byte{3})
+		Expect(ok).To(BeTrue())
+		Expect(b.Delete(deleted.CID().Bytes())).To(Succeed())
+
+		stats, diffs, err := compare(a, b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Differences).To(Equal(uint64(1)))
+		Expect(diffs).To(HaveLen(1))
+		Expect(diffs[deleted.CID()].Difference).To(Equal(validator.OnlyInA))
+	})
+})
@@ -0,0 +1,101 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package validator
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v0"
+	"github.com/cerc-io/ipld-eth-statedb/trie_by_cid/trie"
+)
+
+var getBlockNumberPgStr = "SELECT block_number FROM ipld.blocks WHERE key = $1 LIMIT 1"
+
+// CopyStats summarizes a state copy
+type CopyStats struct {
+	Nodes uint64 // state and storage nodes written
+	Code  uint64 // code blobs written
+}
+
+// CopyState writes every state node, storage node and code blob reachable from the state root into the destination,
+// keyed by CID. The traversal is tracked like a validation, under the "copy" traversal type, so an interrupted copy
+// resumes from its recovery state. Nodes already present in a destination which can be read are not rewritten.
+func (v *Validator) CopyState(stateRoot common.Hash, dst ethdb.KeyValueWriter) (CopyStats, error) {
+	var stats CopyStats
+	t, err := v.stateDatabase.OpenTrie(stateRoot)
+	if err != nil {
+		return stats, err
+	}
+	reader, _ := dst.(ethdb.KeyValueReader)
+	var nodes, code atomic.Uint64
+	visit := func(codec uint64, hash common.Hash, blob []byte, _, _ []byte) error {
+		c, err := keccak256ToCid(codec, hash.Bytes())
+		if err != nil {
+			return err
+		}
+		if reader != nil {
+			if has, err := reader.Has(c.Bytes()); err == nil && has {
+				return nil
+			}
+		}
+		if err := dst.Put(c.Bytes(), blob); err != nil {
+			return err
+		}
+		if codec == cid.Raw {
+			code.Add(1)
+		} else {
+			nodes.Add(1)
+		}
+		return nil
+	}
+	iterate := func(ctx context.Context, it trie.NodeIterator) error { return v.iterate(ctx, it, true, visit) }
+	err = v.iterateRecoverable(t, stateRoot, copyTraversal, iterate)
+	stats.Nodes, stats.Code = nodes.Load(), code.Load()
+	return stats, err
+}
+
+// Counts the writers opened, to give each its own cache name
+var pgipfsWriters atomic.Uint64
+
+// NewPGIPFSWriter returns a database which writes blocks into ipld.blocks with the given block number
+func NewPGIPFSWriter(db *sqlx.DB, blockNumber uint64) ethdb.KeyValueStore {
+	database := pgipfsethdb.NewDatabase(db, pgipfsethdb.CacheConfig{
+		Name:           fmt.Sprintf("writer-%d", pgipfsWriters.Add(1)),
+		Size:           1000 * 1000, // 1MB; blocks are only written and checked for, not read
+		ExpiryDuration: time.Hour,
+	})
+	database.(*pgipfsethdb.Database).BlockNumber = new(big.Int).SetUint64(blockNumber)
+	return database
+}
+
+// StateRootBlockNumber returns the block number the state root node was indexed at in ipld.blocks
+func StateRootBlockNumber(db *sqlx.DB, stateRoot common.Hash) (uint64, error) {
+	c, err := keccak256ToCid(cid.EthStateTrie, stateRoot.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	var blockNumber uint64
+	return blockNumber, db.Get(&blockNumber, getBlockNumberPgStr, c.String())
+}
@@ -0,0 +1,169 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var _ = Describe("State copy", func() {
+	var (
+		source *carblockstore.ReadOnly
+		params validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_copy")
+	})
+	AfterEach(func() {
+		if source != nil {
+			source.Close()
+		}
+		os.RemoveAll(tmp)
+	})
+
+	openSource := func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+		path := filepath.Join(tmp, "source.car")
+		writeCAR(path, false, stateNodes, storageNodes, contractCode...)
+		source, err = validator.OpenCAR(path)
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewCARValidator(source, params)
+	}
+
+	// Checks that every node and code blob of the state is in the destination, keyed by CID
+	expectComplete := func(dst ethdb.KeyValueReader) {
+		for _, block := range trieBlocks(trieStateNodes, trieStorageNodes, mockCode) {
+			value, err := dst.Get(block.Cid().Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(block.RawData()))
+		}
+	}
+
+	It("Copies the state into chaindata, where it can be validated", func() {
+		openSource(trieStateNodes, trieStorageNodes, mockCode)
+		chaindata, err := validator.OpenChaindata(validator.ChaindataConfig{
+			Directory: filepath.Join(tmp, "chaindata"),
+			Type:      "pebble",
+			Cache:     16,
+			Handles:   16,
+			Writable:  true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer chaindata.Close()
+		dst := validator.NewChaindataDatabase(chaindata)
+
+		stats, err := v.CopyState(stateRoot, dst)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Nodes).ToNot(BeZero())
+		Expect(stats.Code).ToNot(BeZero())
+		expectComplete(dst)
+		Expect(v.Close()).To(Succeed())
+
+		v = validator.NewChaindataValidator(chaindata, params)
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+	})
+
+	It("Copies the state into SQLite at the block number, where it can be validated", func() {
+		openSource(trieStateNodes, trieStorageNodes, mockCode)
+		sqlite, err := validator.OpenSQLite(filepath.Join(tmp, "copy.sqlite"), true)
+		Expect(err).ToNot(HaveOccurred())
+		defer sqlite.Close()
+		dst := validator.NewSQLiteDatabase(sqlite)
+		dst.BlockNumber = blockNumber
+
+		stats, err := v.CopyState(stateRoot, dst)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Nodes).ToNot(BeZero())
+		expectComplete(dst)
+		Expect(validator.StateRootBlockNumber(sqlite, stateRoot)).To(Equal(blockNumber))
+		Expect(v.Close()).To(Succeed())
+
+		v = validator.NewSQLiteValidator(sqlite, params)
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+	})
+
+	It("Copies the state into a CAR file, skipping blocks already written", func() {
+		openSource(trieStateNodes, trieStorageNodes, mockCode)
+		root, err := validator.StateRootCID(stateRoot)
+		Expect(err).ToNot(HaveOccurred())
+		path := filepath.Join(tmp, "copy.car")
+		rw, err := carblockstore.OpenReadWrite(path, []cid.Cid{root})
+		Expect(err).ToNot(HaveOccurred())
+		dst := validator.NewBlockstoreDatabase(rw)
+
+		stats, err := v.CopyState(stateRoot, dst)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Nodes).ToNot(BeZero())
+		expectComplete(dst)
+
+		stats, err = v.CopyState(stateRoot, dst)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats).To(Equal(validator.CopyStats{}))
+		Expect(rw.Finalize()).To(Succeed())
+		Expect(v.Close()).To(Succeed())
+
+		car, err := validator.OpenCAR(path)
+		Expect(err).ToNot(HaveOccurred())
+		defer car.Close()
+		v = validator.NewCARValidator(car, params)
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+	})
+
+	It("Copies the state into Postgres more than once in a process", Label("postgres"), func() {
+		db, err = openTestDB()
+		Expect(err).ToNot(HaveOccurred())
+		defer ResetTestDB(db)
+		openSource(trieStateNodes, trieStorageNodes, mockCode)
+
+		for i := 0; i < 2; i++ {
+			Expect(ResetTestDB(db)).To(Succeed())
+			dst := validator.NewPGIPFSWriter(db, blockNumber)
+			stats, err := v.CopyState(stateRoot, dst)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.Nodes).ToNot(BeZero())
+			expectComplete(dst)
+		}
+		Expect(validator.StateRootBlockNumber(db, stateRoot)).To(Equal(blockNumber))
+		Expect(v.Close()).To(Succeed())
+
+		v = validator.NewPGIPFSValidator(db, params)
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+		Expect(v.Close()).To(Succeed())
+	})
+
+	It("Returns an error if the source is missing node(s)", func() {
+		openSource(trieStateNodes, missingNodeStorageNodes, mockCode)
+		root, err := validator.StateRootCID(stateRoot)
+		Expect(err).ToNot(HaveOccurred())
+		rw, err := carblockstore.OpenReadWrite(filepath.Join(tmp, "copy.car"), []cid.Cid{root})
+		Expect(err).ToNot(HaveOccurred())
+		defer rw.Discard()
+
+		_, err = v.CopyState(stateRoot, validator.NewBlockstoreDatabase(rw))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+	})
+})
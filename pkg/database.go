@@ -17,9 +17,12 @@
 package validator
 
 import (
-	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/spf13/viper"
@@ -32,6 +35,8 @@ const (
 	DATABASE_PORT     = "DATABASE_PORT"
 	DATABASE_USER     = "DATABASE_USER"
 	DATABASE_PASSWORD = "DATABASE_PASSWORD"
+	DATABASE_URL      = "DATABASE_URL"
+	DATABASE_SSLMODE  = "DATABASE_SSLMODE"
 )
 
 type Config struct {
@@ -40,25 +45,80 @@ type Config struct {
 	User     string
 	Password string
 	Port     int
+
+	URL             string        // a postgres:// URL or key=value connection string used instead of the fields above
+	SSLMode         string        // disable, require, verify-ca or verify-full; defaults to PGSSLMODE, or else disable
+	SSLCert         string        // client certificate file
+	SSLKey          string        // client key file
+	SSLRootCert     string        // CA certificate file, for verify-ca and verify-full
+	ConnectTimeout  time.Duration // rounded up to seconds, as libpq takes a 0 to mean no timeout
+	ApplicationName string
+
+	MaxOpenConns    int // 0 is unlimited
+	MaxIdleConns    int // 0 keeps the database/sql default of 2
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
 // NewDB returns a new sqlx.DB from config/cli/env variables
 func NewDB() (*sqlx.DB, error) {
 	c := Config{}
 	LoadViper(&c)
-	return sqlx.Connect("postgres", c.ConnString())
+	return Connect(c)
 }
 
+// Connect opens a database with the config and applies its pool settings
+func Connect(c Config) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("postgres", c.ConnString())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	return db, nil
+}
+
+// ConnString returns the connection string for the config
+// Empty fields are left out, so libpq's environment variables (PGHOST, PGPORT, PGPASSWORD, PGPASSFILE, PGSSLMODE,
+// PGSSLROOTCERT, PGAPPNAME, ...) and ~/.pgpass fill them in.
 func (c *Config) ConnString() string {
+	if c.URL != "" {
+		return c.URL
+	}
+	u := url.URL{Scheme: "postgresql", Host: c.Hostname, Path: "/" + c.Name}
+	if c.Port != 0 {
+		u.Host = net.JoinHostPort(c.Hostname, strconv.Itoa(c.Port))
+	}
 	if len(c.User) > 0 && len(c.Password) > 0 {
-		return fmt.Sprintf("postgresql://%s:%s@%s:%d/%s?sslmode=disable",
-			c.User, c.Password, c.Hostname, c.Port, c.Name)
+		u.User = url.UserPassword(c.User, c.Password)
+	} else if len(c.User) > 0 {
+		u.User = url.User(c.User)
 	}
-	if len(c.User) > 0 && len(c.Password) == 0 {
-		return fmt.Sprintf("postgresql://%s@%s:%d/%s?sslmode=disable",
-			c.User, c.Hostname, c.Port, c.Name)
+	q := url.Values{}
+	sslMode := c.SSLMode
+	if sslMode == "" && os.Getenv("PGSSLMODE") == "" {
+		sslMode = "disable" // rather than libpq's default of require
 	}
-	return fmt.Sprintf("postgresql://%s:%d/%s?sslmode=disable", c.Hostname, c.Port, c.Name)
+	for key, value := range map[string]string{
+		"sslmode":          sslMode,
+		"sslcert":          c.SSLCert,
+		"sslkey":           c.SSLKey,
+		"sslrootcert":      c.SSLRootCert,
+		"application_name": c.ApplicationName,
+	} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	if c.ConnectTimeout > 0 {
+		q.Set("connect_timeout", strconv.Itoa(int(math.Ceil(c.ConnectTimeout.Seconds()))))
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
 func LoadEnv(c *Config) error {
@@ -81,6 +141,12 @@ func LoadEnv(c *Config) error {
 	if val := os.Getenv(DATABASE_PASSWORD); val != "" {
 		c.Password = val
 	}
+	if val := os.Getenv(DATABASE_URL); val != "" {
+		c.URL = val
+	}
+	if val := os.Getenv(DATABASE_SSLMODE); val != "" {
+		c.SSLMode = val
+	}
 	return nil
 }
 
@@ -90,10 +156,33 @@ func LoadViper(c *Config) {
 	viper.BindEnv("database.port", DATABASE_PORT)
 	viper.BindEnv("database.user", DATABASE_USER)
 	viper.BindEnv("database.password", DATABASE_PASSWORD)
+	viper.BindEnv("database.url", DATABASE_URL)
+	viper.BindEnv("database.sslmode", DATABASE_SSLMODE)
 
 	c.Name = viper.GetString("database.name")
 	c.Hostname = viper.GetString("database.hostname")
 	c.Port = viper.GetInt("database.port")
 	c.User = viper.GetString("database.user")
 	c.Password = viper.GetString("database.password")
+	c.URL = viper.GetString("database.url")
+	c.SSLMode = viper.GetString("database.sslmode")
+	c.SSLCert = viper.GetString("database.sslcert")
+	c.SSLKey = viper.GetString("database.sslkey")
+	c.SSLRootCert = viper.GetString("database.sslrootcert")
+	c.ConnectTimeout = viper.GetDuration("database.connectTimeout")
+	c.ApplicationName = viper.GetString("database.applicationName")
+
+	c.MaxOpenConns = viper.GetInt("database.maxOpenConns")
+	c.MaxIdleConns = viper.GetInt("database.maxIdleConns")
+	c.ConnMaxLifetime = viper.GetDuration("database.connMaxLifetime")
+	c.ConnMaxIdleTime = viper.GetDuration("database.connMaxIdleTime")
+	// size the pool to the workers, each of which holds a connection per lookup, plus the recovery store and repair writer
+	if c.MaxOpenConns == 0 {
+		if workers := viper.GetInt("validator.workers"); workers > 0 {
+			c.MaxOpenConns = workers + 2
+		}
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = c.MaxOpenConns
+	}
 }
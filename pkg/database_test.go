@@ -0,0 +1,91 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var _ = Describe("Database config", func() {
+	// Parses a connection string into libpq's key=value form
+	parse := func(c validator.Config) string {
+		dsn, err := pq.ParseURL(c.ConnString())
+		Expect(err).ToNot(HaveOccurred())
+		return dsn
+	}
+	unsetEnv := func(key string) {
+		if val, ok := os.LookupEnv(key); ok {
+			os.Unsetenv(key)
+			DeferCleanup(os.Setenv, key, val)
+		}
+	}
+
+	It("Disables TLS by default", func() {
+		unsetEnv("PGSSLMODE")
+		Expect(parse(validator.Config{Hostname: "db", Port: 5432, Name: "cerc_public", User: "vdbm", Password: "pw"})).To(Equal(
+			"dbname='cerc_public' host='db' password='pw' port='5432' sslmode='disable' user='vdbm'"))
+	})
+	It("Leaves unset fields to libpq's environment", func() {
+		DeferCleanup(os.Setenv, "PGSSLMODE", os.Getenv("PGSSLMODE"))
+		os.Setenv("PGSSLMODE", "verify-full")
+		Expect(parse(validator.Config{Name: "cerc_public", User: "vdbm"})).To(Equal("dbname='cerc_public' user='vdbm'"))
+	})
+	It("Passes TLS and connection options", func() {
+		Expect(parse(validator.Config{
+			Hostname:        "db.example.com",
+			Name:            "cerc_public",
+			SSLMode:         "verify-full",
+			SSLCert:         "/certs/client.crt",
+			SSLKey:          "/certs/client.key",
+			SSLRootCert:     "/certs/ca.crt",
+			ConnectTimeout:  10 * time.Second,
+			ApplicationName: "validator",
+		})).To(Equal("application_name='validator' connect_timeout='10' dbname='cerc_public' host='db.example.com' " +
+			"sslcert='/certs/client.crt' sslkey='/certs/client.key' sslmode='verify-full' sslrootcert='/certs/ca.crt'"))
+	})
+	It("Rounds the connect timeout up to seconds", func() {
+		Expect(parse(validator.Config{Name: "cerc_public", ConnectTimeout: 500 * time.Millisecond, SSLMode: "disable"})).To(Equal(
+			"connect_timeout='1' dbname='cerc_public' sslmode='disable'"))
+	})
+	It("Uses a connection string as given", func() {
+		dsn := "host=db dbname=cerc_public sslmode=require"
+		Expect((&validator.Config{URL: dsn, Hostname: "other", SSLMode: "disable"}).ConnString()).To(Equal(dsn))
+	})
+	It("Sizes the pool to the workers", func() {
+		DeferCleanup(viper.Reset)
+		viper.Set("validator.workers", 8)
+		var c validator.Config
+		validator.LoadViper(&c)
+		Expect(c.MaxOpenConns).To(Equal(10))
+		Expect(c.MaxIdleConns).To(Equal(10))
+
+		viper.Set("database.maxOpenConns", 3)
+		viper.Set("database.connMaxLifetime", "5m")
+		validator.LoadViper(&c)
+		Expect(c.MaxOpenConns).To(Equal(3))
+		Expect(c.MaxIdleConns).To(Equal(3))
+		Expect(c.ConnMaxLifetime).To(Equal(5 * time.Minute))
+	})
+})
@@ -0,0 +1,405 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Postgres COPY formats
+const (
+	TextDump   = "text"
+	CSVDump    = "csv"
+	BinaryDump = "binary"
+)
+
+// DefaultDumpColumns is the column order of ipld.blocks, as written by COPY ipld.blocks TO
+var DefaultDumpColumns = []string{"block_number", "key", "data"}
+
+// Keys under which the index records the dump it was built from, and the dump last being ingested into it
+var (
+	dumpIndexedKey   = []byte("validator/dump/indexed")
+	dumpIngestingKey = []byte("validator/dump/ingesting")
+)
+
+// DumpConfig configures the ingestion of a COPY dump of ipld.blocks
+type DumpConfig struct {
+	Path     string   // dump file; read through gzip if it ends in .gz
+	Format   string   // TextDump, CSVDump or BinaryDump
+	Columns  []string // column order of the dump; must include key and data; defaults to DefaultDumpColumns
+	Header   bool     // whether a CSV dump starts with a header line
+	IndexDir string   // directory of the on-disk index; an index built from the same dump is reused
+}
+
+// DumpStats summarizes the ingestion of a dump
+type DumpStats struct {
+	Rows   uint64 // rows read from the dump
+	Reused bool   // whether an existing index was reused rather than ingesting the dump
+}
+
+// IngestDump loads the blocks in a COPY ipld.blocks dump into an on-disk LevelDB index keyed by CID, and returns it
+// If the index was already built from the same dump (by path, size and modification time), it is returned as is. An
+// index holding blocks of any other dump is emptied first.
+func IngestDump(c DumpConfig) (ethdb.Database, DumpStats, error) {
+	var stats DumpStats
+	columns := c.Columns
+	if len(columns) == 0 {
+		columns = DefaultDumpColumns
+	}
+	keyCol, dataCol := indexOf(columns, "key"), indexOf(columns, "data")
+	if keyCol < 0 || dataCol < 0 {
+		return nil, stats, fmt.Errorf("dump columns must include key and data: %v", columns)
+	}
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return nil, stats, err
+	}
+	identity := []byte(fmt.Sprintf("%s %d %d", c.Path, info.Size(), info.ModTime().UnixNano()))
+
+	db, err := rawdb.NewLevelDBDatabase(c.IndexDir, 256, 256, "validator/dump/", false)
+	if err != nil {
+		return nil, stats, err
+	}
+	if indexed, _ := db.Get(dumpIndexedKey); bytes.Equal(indexed, identity) {
+		stats.Reused = true
+		return db, stats, nil
+	}
+	// an interrupted ingestion of the same dump is overwritten, but blocks of another dump must not be validated with it
+	if ingesting, _ := db.Get(dumpIngestingKey); !bytes.Equal(ingesting, identity) {
+		if err := clearIndex(db); err != nil {
+			db.Close()
+			return nil, stats, err
+		}
+	}
+	if err := db.Put(dumpIngestingKey, identity); err != nil {
+		db.Close()
+		return nil, stats, err
+	}
+
+	f, err := os.Open(c.Path)
+	if err != nil {
+		db.Close()
+		return nil, stats, err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReaderSize(f, 1<<20)
+	if strings.HasSuffix(c.Path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			db.Close()
+			return nil, stats, err
+		}
+		r = bufio.NewReaderSize(gz, 1<<20)
+	}
+
+	batch := db.NewBatch()
+	row := func(fields [][]byte) error {
+		if len(fields) != len(columns) {
+			return fmt.Errorf("row %d has %d columns, expected %d", stats.Rows+1, len(fields), len(columns))
+		}
+		key, err := cid.Decode(string(fields[keyCol]))
+		if err != nil {
+			return fmt.Errorf("row %d: %w", stats.Rows+1, err)
+		}
+		if err := batch.Put(key.Bytes(), fields[dataCol]); err != nil {
+			return err
+		}
+		stats.Rows++
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if stats.Rows%1000000 == 0 {
+			log.Infof("ingested %d rows from %s", stats.Rows, c.Path)
+		}
+		return nil
+	}
+	switch c.Format {
+	case TextDump, "":
+		err = readTextDump(r, dataCol, row)
+	case CSVDump:
+		err = readCSVDump(r, c.Header, dataCol, row)
+	case BinaryDump:
+		err = readBinaryDump(r, row)
+	default:
+		err = fmt.Errorf("invalid dump format: '%s'", c.Format)
+	}
+	if err == nil {
+		err = batch.Write()
+	}
+	if err == nil {
+		err = db.Put(dumpIndexedKey, identity)
+	}
+	if err != nil {
+		db.Close()
+		return nil, stats, err
+	}
+	return db, stats, nil
+}
+
+// Deletes every key of an index
+func clearIndex(db ethdb.Database) error {
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	batch := db.NewBatch()
+	for it.Next() {
+		if err := batch.Delete(it.Key()); err != nil {
+			return err
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// NewDumpValidator returns a new trie validator ontop of an index built by IngestDump
+func NewDumpValidator(db ethdb.Database, par Params) *Validator {
+	return newValidator(db, db, "dump", par)
+}
+
+func indexOf(columns []string, name string) int {
+	for i, col := range columns {
+		if col == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reads COPY text format: tab-separated columns with backslash escapes, bytea in hex or escape format
+func readTextDump(r io.Reader, dataCol int, row func([][]byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1<<20), 1<<30)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if bytes.Equal(line, []byte(`\.`)) {
+			break
+		}
+		fields := bytes.Split(line, []byte{'\t'})
+		for i := range fields {
+			fields[i] = unescapeCopyText(fields[i])
+		}
+		if dataCol < len(fields) {
+			data, err := decodeBytea(fields[dataCol])
+			if err != nil {
+				return err
+			}
+			fields[dataCol] = data
+		}
+		if err := row(fields); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Reads COPY CSV format, with bytea in hex or escape format
+func readCSVDump(r io.Reader, header bool, dataCol int, row func([][]byte) error) error {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first && header {
+			continue
+		}
+		fields := make([][]byte, len(record))
+		for i, field := range record {
+			fields[i] = []byte(field)
+		}
+		if dataCol < len(fields) {
+			if fields[dataCol], err = decodeBytea(fields[dataCol]); err != nil {
+				return err
+			}
+		}
+		if err := row(fields); err != nil {
+			return err
+		}
+	}
+}
+
+// Signature of the COPY binary format
+var copyBinarySignature = []byte("PGCOPY\n\377\r\n\000")
+
+// Reads COPY binary format, in which text and bytea fields are their raw bytes
+func readBinaryDump(r io.Reader, row func([][]byte) error) error {
+	header := make([]byte, len(copyBinarySignature)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:len(copyBinarySignature)], copyBinarySignature) {
+		return errors.New("not a COPY binary dump")
+	}
+	extension := binary.BigEndian.Uint32(header[len(copyBinarySignature)+4:])
+	if _, err := io.CopyN(io.Discard, r, int64(extension)); err != nil {
+		return err
+	}
+	var count int16
+	for {
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return err
+		}
+		if count == -1 {
+			return nil
+		}
+		fields := make([][]byte, count)
+		for i := range fields {
+			var length int32
+			if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+				return err
+			}
+			if length < 0 {
+				continue
+			}
+			fields[i] = make([]byte, length)
+			if _, err := io.ReadFull(r, fields[i]); err != nil {
+				return err
+			}
+		}
+		if err := row(fields); err != nil {
+			return err
+		}
+	}
+}
+
+// Undoes the backslash escapes of COPY text format
+func unescapeCopyText(field []byte) []byte {
+	if bytes.IndexByte(field, '\\') < 0 {
+		return field
+	}
+	out := make([]byte, 0, len(field))
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' || i == len(field)-1 {
+			out = append(out, field[i])
+			continue
+		}
+		i++
+		switch c := field[i]; c {
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'v':
+			out = append(out, '\v')
+		case 'x':
+			n, v := 0, byte(0)
+			for ; n < 2 && i+1 < len(field) && isHexDigit(field[i+1]); n++ {
+				i++
+				v = v<<4 | hexValue(field[i])
+			}
+			if n == 0 {
+				out = append(out, 'x')
+			} else {
+				out = append(out, v)
+			}
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v := c - '0'
+			for n := 1; n < 3 && i+1 < len(field) && field[i+1] >= '0' && field[i+1] <= '7'; n++ {
+				i++
+				v = v<<3 | (field[i] - '0')
+			}
+			out = append(out, v)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Decodes a bytea value from its text output, in hex (\x...) or escape format
+func decodeBytea(value []byte) ([]byte, error) {
+	if bytes.HasPrefix(value, []byte(`\x`)) {
+		out := make([]byte, hex.DecodedLen(len(value)-2))
+		_, err := hex.Decode(out, value[2:])
+		return out, err
+	}
+	out := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			out = append(out, value[i])
+			continue
+		}
+		switch {
+		case i+1 < len(value) && value[i+1] == '\\':
+			out = append(out, '\\')
+			i++
+		case i+3 < len(value):
+			v := byte(0)
+			for _, d := range value[i+1 : i+4] {
+				if d < '0' || d > '7' {
+					return nil, fmt.Errorf("invalid bytea escape: %q", value[i:i+4])
+				}
+				v = v<<3 | (d - '0')
+			}
+			out = append(out, v)
+			i += 3
+		default:
+			return nil, fmt.Errorf("invalid bytea escape at end of value")
+		}
+	}
+	return out, nil
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	default:
+		return c - '0'
+	}
+}
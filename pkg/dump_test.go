@@ -0,0 +1,171 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+// A row of ipld.blocks
+type blockRow struct {
+	blockNumber uint64
+	key         string
+	data        []byte
+}
+
+func blockRows(stateNodes, storageNodes [][]byte, contractCode ...[]byte) []blockRow {
+	var rows []blockRow
+	for _, block := range trieBlocks(stateNodes, storageNodes, contractCode...) {
+		rows = append(rows, blockRow{1, block.Cid().String(), block.RawData()})
+	}
+	return rows
+}
+
+// Encodes rows as COPY ipld.blocks TO would, in the given format
+func encodeDump(format string, rows []blockRow) []byte {
+	var buf bytes.Buffer
+	switch format {
+	case validator.TextDump:
+		for _, r := range rows {
+			fmt.Fprintf(&buf, "%d\t%s\t\\\\x%x\n", r.blockNumber, r.key, r.data)
+		}
+	case validator.CSVDump:
+		buf.WriteString("block_number,key,data\n")
+		for _, r := range rows {
+			fmt.Fprintf(&buf, "%d,%s,\\x%x\n", r.blockNumber, r.key, r.data)
+		}
+	case validator.BinaryDump:
+		buf.WriteString("PGCOPY\n\377\r\n\000")
+		binary.Write(&buf, binary.BigEndian, [2]int32{0, 0})
+		for _, r := range rows {
+			binary.Write(&buf, binary.BigEndian, int16(3))
+			binary.Write(&buf, binary.BigEndian, int32(8))
+			binary.Write(&buf, binary.BigEndian, r.blockNumber)
+			for _, field := range [][]byte{[]byte(r.key), r.data} {
+				binary.Write(&buf, binary.BigEndian, int32(len(field)))
+				buf.Write(field)
+			}
+		}
+		binary.Write(&buf, binary.BigEndian, int16(-1))
+	}
+	return buf.Bytes()
+}
+
+var _ = Describe("Dump validator", func() {
+	var (
+		index  ethdb.Database
+		params validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_dump")
+	})
+	AfterEach(func() {
+		if index != nil {
+			index.Close()
+			index = nil
+		}
+		os.RemoveAll(tmp)
+	})
+
+	ingest := func(config validator.DumpConfig) validator.DumpStats {
+		config.IndexDir = filepath.Join(tmp, "index")
+		var stats validator.DumpStats
+		index, stats, err = validator.IngestDump(config)
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewDumpValidator(index, params)
+		return stats
+	}
+	writeDump := func(format string, rows []blockRow) string {
+		path := filepath.Join(tmp, "blocks."+format)
+		Expect(os.WriteFile(path, encodeDump(format, rows), 0644)).To(Succeed())
+		return path
+	}
+
+	for _, format := range []string{validator.TextDump, validator.CSVDump, validator.BinaryDump} {
+		format := format
+		Describe(format, func() {
+			itValidatesStorageTries(func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+				rows := blockRows(stateNodes, storageNodes, contractCode...)
+				stats := ingest(validator.DumpConfig{Path: writeDump(format, rows), Format: format, Header: true})
+				Expect(stats.Rows).To(BeNumerically("==", len(rows)))
+			})
+		})
+	}
+
+	It("Reads gzipped dumps", func() {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(encodeDump(validator.TextDump, blockRows(trieStateNodes, trieStorageNodes, mockCode)))
+		Expect(gz.Close()).To(Succeed())
+		path := filepath.Join(tmp, "blocks.txt.gz")
+		Expect(os.WriteFile(path, buf.Bytes(), 0644)).To(Succeed())
+		ingest(validator.DumpConfig{Path: path, Format: validator.TextDump})
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+	})
+	It("Reads dumps of selected columns, in escape format", func() {
+		var buf bytes.Buffer
+		for _, r := range blockRows(trieStateNodes, trieStorageNodes, mockCode) {
+			var escaped strings.Builder
+			for _, b := range r.data {
+				fmt.Fprintf(&escaped, "\\\\%03o", b)
+			}
+			fmt.Fprintf(&buf, "%s\t%s\n", r.key, escaped.String())
+		}
+		path := filepath.Join(tmp, "blocks.txt")
+		Expect(os.WriteFile(path, buf.Bytes(), 0644)).To(Succeed())
+		ingest(validator.DumpConfig{Path: path, Columns: []string{"key", "data"}})
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+	})
+	It("Reuses the index of the same dump", func() {
+		path := writeDump(validator.TextDump, blockRows(trieStateNodes, trieStorageNodes, mockCode))
+		Expect(ingest(validator.DumpConfig{Path: path}).Reused).To(BeFalse())
+		index.Close()
+		Expect(ingest(validator.DumpConfig{Path: path}).Reused).To(BeTrue())
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+	})
+	It("Empties the index of another dump", func() {
+		complete := writeDump(validator.TextDump, blockRows(trieStateNodes, trieStorageNodes, mockCode))
+		ingest(validator.DumpConfig{Path: complete})
+		index.Close()
+		incomplete := filepath.Join(tmp, "incomplete.txt")
+		Expect(os.WriteFile(incomplete, encodeDump(validator.TextDump,
+			blockRows(trieStateNodes, missingNodeStorageNodes, mockCode)), 0644)).To(Succeed())
+		Expect(ingest(validator.DumpConfig{Path: incomplete}).Reused).To(BeFalse())
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+	})
+	It("Returns an error for malformed dumps", func() {
+		path := filepath.Join(tmp, "blocks.txt")
+		Expect(os.WriteFile(path, []byte("1\tnot-a-cid\t\\\\x00\n"), 0644)).To(Succeed())
+		_, _, err := validator.IngestDump(validator.DumpConfig{Path: path, IndexDir: filepath.Join(tmp, "index")})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,160 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"golang.org/x/sync/errgroup"
+
+	iterutils "github.com/cerc-io/eth-iterator-utils"
+)
+
+// ExportParams configures a CAR export
+type ExportParams struct {
+	Path   string // output file; with more than one shard, %d is substituted with the shard index
+	Shards uint   // number of files the key space is split across, by state trie path; must be a power of two
+}
+
+// ExportStats summarizes a CAR export
+type ExportStats struct {
+	Files  []string
+	Blocks uint64 // unique blocks written, summed over the files
+}
+
+// ExportCAR traverses the state and storage tries and contract code for the state root, as ValidateTrie does, and
+// writes every unique node and code blob to CARv1 files rooted at the state root's CID.
+// Each shard holds the state nodes in its range of paths, with the storage nodes and code of the accounts in it, so
+// storage tries and code shared between accounts in different shards are written to each of them. The state root node
+// is written to every shard, so that each holds the root named in its header.
+func (v *Validator) ExportCAR(stateRoot common.Hash, par ExportParams) (ExportStats, error) {
+	var stats ExportStats
+	if par.Shards == 0 {
+		par.Shards = 1
+	}
+	if par.Shards&(par.Shards-1) != 0 {
+		return stats, fmt.Errorf("number of shards must be a power of two: %d", par.Shards)
+	}
+	if par.Shards > 1 && !strings.Contains(par.Path, "%d") {
+		return stats, fmt.Errorf("path must contain %%d when exporting to multiple shards: %s", par.Path)
+	}
+	t, err := v.stateDatabase.OpenTrie(stateRoot)
+	if err != nil {
+		return stats, err
+	}
+	root, err := keccak256ToCid(cid.EthStateTrie, stateRoot.Bytes())
+	if err != nil {
+		return stats, err
+	}
+
+	writers := make([]*carblockstore.ReadWrite, par.Shards)
+	for i := range writers {
+		file := par.Path
+		if par.Shards > 1 {
+			file = fmt.Sprintf(par.Path, i)
+		}
+		writers[i], err = carblockstore.OpenReadWrite(file, []cid.Cid{root}, carblockstore.WriteAsCarV1(true))
+		if err != nil {
+			for _, w := range writers[:i] {
+				w.Discard()
+			}
+			return stats, err
+		}
+		stats.Files = append(stats.Files, file)
+	}
+	locks := make([]sync.Mutex, par.Shards)
+	var written atomic.Uint64
+	put := func(shard uint, block blocks.Block) error {
+		locks[shard].Lock()
+		defer locks[shard].Unlock()
+		if has, err := writers[shard].Has(context.Background(), block.Cid()); err != nil || has {
+			return err
+		}
+		written.Add(1)
+		return writers[shard].Put(context.Background(), block)
+	}
+	visit := func(codec uint64, hash common.Hash, blob []byte, statePath, _ []byte) error {
+		c, err := keccak256ToCid(codec, hash.Bytes())
+		if err != nil {
+			return err
+		}
+		block, err := blocks.NewBlockWithCid(blob, c)
+		if err != nil {
+			return err
+		}
+		shards := []uint{shardOf(statePath, par.Shards)}
+		if c.Equals(root) {
+			shards = shards[:0]
+			for shard := range writers {
+				shards = append(shards, uint(shard))
+			}
+		}
+		for _, shard := range shards {
+			if err := put(shard, block); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// use at least as many iterators as shards, so each iterator's range falls within one shard
+	nbins := par.Shards
+	for nbins < v.params.Workers {
+		nbins *= 2
+	}
+	v.progress = newProgress()
+	stopReporting := v.progress.start(v.params.ProgressInterval, v.params.ProgressBar)
+	g, ctx := errgroup.WithContext(context.Background())
+	sem := make(chan struct{}, v.params.Workers)
+	for _, it := range iterutils.SubtrieIterators(t.NodeIterator, nbins) {
+		it := v.progress.trackWorker(it)
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			return v.iterate(ctx, it, true, visit)
+		})
+	}
+	err = g.Wait()
+	stopReporting()
+	stats.Blocks = written.Load()
+
+	for _, w := range writers {
+		if err != nil {
+			w.Discard()
+			continue
+		}
+		err = w.Finalize()
+	}
+	return stats, err
+}
+
+// Returns the index of the shard a state trie path falls in
+func shardOf(path []byte, shards uint) uint {
+	shard := uint(pathPosition(path) * float64(shards))
+	if shard >= shards {
+		shard = shards - 1
+	}
+	return shard
+}
@@ -0,0 +1,125 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package validator_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var _ = Describe("CAR export", func() {
+	var (
+		source   *carblockstore.ReadOnly
+		expected map[cid.Cid]bool
+	)
+
+	BeforeEach(func() {
+		params := makeTmp("test_export")
+		path := filepath.Join(tmp, "source.car")
+		writeCAR(path, false, trieStateNodes, trieStorageNodes, mockCode)
+		source, err = validator.OpenCAR(path)
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewCARValidator(source, params)
+
+		expected = make(map[cid.Cid]bool)
+		for _, block := range trieBlocks(trieStateNodes, trieStorageNodes, mockCode) {
+			expected[block.Cid()] = true
+		}
+	})
+	AfterEach(func() {
+		source.Close()
+		os.RemoveAll(tmp)
+	})
+
+	It("Writes every node and code blob to a CARv1 file rooted at the state root", func() {
+		out := filepath.Join(tmp, "state.car")
+		stats, err := v.ExportCAR(stateRoot, validator.ExportParams{Path: out})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Files).To(Equal([]string{out}))
+		Expect(stats.Blocks).To(BeNumerically("==", len(expected)))
+
+		roots, exported := readCAR(out)
+		Expect(roots).To(HaveLen(1))
+		Expect(expected).To(HaveKey(roots[0]))
+		Expect(exported).To(Equal(expected))
+
+		car, err := validator.OpenCAR(out)
+		Expect(err).ToNot(HaveOccurred())
+		defer car.Close()
+		err = validator.NewCARValidator(car, validator.Params{RecoveryFormat: filepath.Join(tmp, "recover_%s")}).ValidateTrie(stateRoot)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	It("Splits the export into shards by path", func() {
+		stats, err := v.ExportCAR(stateRoot, validator.ExportParams{Path: filepath.Join(tmp, "state-%d.car"), Shards: 4})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Files).To(HaveLen(4))
+
+		exported := make(map[cid.Cid]bool)
+		for _, file := range stats.Files {
+			roots, blocks := readCAR(file)
+			Expect(roots).To(HaveLen(1))
+			Expect(blocks).To(HaveKey(roots[0]))
+			for c := range blocks {
+				exported[c] = true
+			}
+		}
+		Expect(exported).To(Equal(expected))
+	})
+	It("Fails if the state is incomplete", func() {
+		writeCAR(filepath.Join(tmp, "incomplete.car"), false, trieStateNodes, missingNodeStorageNodes, mockCode)
+		car, err := validator.OpenCAR(filepath.Join(tmp, "incomplete.car"))
+		Expect(err).ToNot(HaveOccurred())
+		defer car.Close()
+		incomplete := validator.NewCARValidator(car, validator.Params{RecoveryFormat: filepath.Join(tmp, "recover_%s")})
+		_, err = incomplete.ExportCAR(stateRoot, validator.ExportParams{Path: filepath.Join(tmp, "state.car")})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+	})
+	It("Requires a power of two shards", func() {
+		_, err := v.ExportCAR(stateRoot, validator.ExportParams{Path: filepath.Join(tmp, "state-%d.car"), Shards: 3})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// Returns the roots and block CIDs of a CAR file
+func readCAR(path string) ([]cid.Cid, map[cid.Cid]bool) {
+	file, err := os.Open(path)
+	Expect(err).ToNot(HaveOccurred())
+	defer file.Close()
+	reader, err := carv2.NewBlockReader(file)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(reader.Version).To(BeNumerically("==", 1))
+	cids := make(map[cid.Cid]bool)
+	for {
+		block, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cids).ToNot(HaveKey(block.Cid()))
+		cids[block.Cid()] = true
+	}
+	return reader.Roots, cids
+}
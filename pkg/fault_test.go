@@ -0,0 +1,153 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("Fault injection", func() {
+	var (
+		fixture *validatortest.Fixture
+		store   ethdb.KeyValueStore
+		params  validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_fault")
+		fixture, err = validatortest.RandomFixture(rand.New(rand.NewSource(2)), 32, 8)
+		Expect(err).ToNot(HaveOccurred())
+		store = validatortest.NewMemoryStore()
+		Expect(fixture.Publish(store)).To(Succeed())
+	})
+	AfterEach(func() {
+		v.Close()
+		os.RemoveAll(tmp)
+	})
+
+	// Returns the state node at the path, which the fixture must have
+	stateNode := func(path []byte) validatortest.Node {
+		for _, n := range fixture.Nodes {
+			if n.Codec == cid.EthStateTrie && string(n.Path) == string(path) {
+				return n
+			}
+		}
+		Fail("no state node at the path")
+		return validatortest.Node{}
+	}
+
+	It("Reports dropped keys as missing nodes at their paths", func() {
+		node := stateNode([]byte{0x5})
+		faulty := validatortest.NewFaultyStore(store, validatortest.Faults{Drop: validatortest.CIDs(node.CID())})
+		v = validatortest.NewMemoryValidator(faulty, params)
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+		Expect(err.Error()).To(ContainSubstring("%x", node.Path))
+		Expect(faulty.Stats().Dropped).ToNot(BeZero())
+	})
+	It("Fails validation on corrupted values", func() {
+		node := stateNode([]byte{0x5})
+		faulty := validatortest.NewFaultyStore(store, validatortest.Faults{Corrupt: validatortest.CIDs(node.CID())})
+		v = validatortest.NewMemoryValidator(faulty, params)
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("decode error"))
+		Expect(faulty.Stats().Corrupted).ToNot(BeZero())
+	})
+	It("Resumes from the recovery file after a transient error", func() {
+		var code validatortest.Node
+		for _, n := range fixture.Nodes {
+			if n.Codec == cid.Raw {
+				code = n
+			}
+		}
+		faulty := validatortest.NewFaultyStore(store, validatortest.Faults{Fail: validatortest.CIDs(code.CID()), FailFirst: 1})
+		v = validatortest.NewMemoryValidator(faulty, params)
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(validatortest.ErrTransient.Error()))
+		Expect(err.Error()).To(ContainSubstring("%x", code.Hash))
+		Expect(filepath.Join(tmp, "recover_full")).To(BeAnExistingFile())
+		Expect(v.Close()).To(Succeed())
+
+		// the failure is not injected again, and the restored workers do not read the nodes before their positions again
+		reads := faulty.Stats().Reads
+		v = validatortest.NewMemoryValidator(faulty, params)
+		Expect(v.ValidateTrie(fixture.StateRoot)).To(Succeed())
+		Expect(faulty.Stats().Reads - reads).To(BeNumerically("<", len(fixture.Nodes)))
+		Expect(faulty.Stats().Errors).To(Equal(uint64(1)))
+		Expect(filepath.Join(tmp, "recover_full")).ToNot(BeAnExistingFile())
+	})
+	It("Injects the same random faults for the same seed", func() {
+		faults := validatortest.Faults{DropRate: 0.2, CorruptRate: 0.2, ErrorRate: 0.2, Seed: 42}
+		read := func() ([]bool, validatortest.FaultStats) {
+			faulty := validatortest.NewFaultyStore(store, faults)
+			var failed []bool
+			for _, n := range fixture.Nodes {
+				value, err := faulty.Get(n.CID().Bytes())
+				failed = append(failed, err != nil || string(value) != string(n.Data))
+			}
+			return failed, faulty.Stats()
+		}
+		first, stats := read()
+		Expect(stats.Reads).To(Equal(uint64(len(fixture.Nodes))))
+		Expect(stats.Dropped).ToNot(BeZero())
+		Expect(stats.Corrupted).ToNot(BeZero())
+		Expect(stats.Errors).ToNot(BeZero())
+		second, _ := read()
+		Expect(second).To(Equal(first))
+	})
+	It("Adds latency to reads", func() {
+		faulty := validatortest.NewFaultyStore(store, validatortest.Faults{Latency: 20 * time.Millisecond, Jitter: time.Millisecond})
+		started := time.Now()
+		_, err := faulty.Get(fixture.Nodes[0].CID().Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(started)).To(BeNumerically(">=", 20*time.Millisecond))
+	})
+	It("Wraps blockstores", func() {
+		bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		for _, n := range fixture.Nodes {
+			block, err := blocks.NewBlockWithCid(n.Data, n.CID())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bs.Put(context.Background(), block)).To(Succeed())
+		}
+		node := stateNode([]byte{0x5})
+		faulty := validatortest.NewFaultyBlockstore(bs, validatortest.Faults{Drop: validatortest.CIDs(node.CID())})
+		v = validator.NewKeyValueValidator(validator.NewBlockstoreDatabase(faulty), "faulty", params)
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+		Expect(faulty.Stats().Dropped).ToNot(BeZero())
+	})
+})
@@ -0,0 +1,115 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ipfs/go-cid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("validatortest fixtures", func() {
+	var (
+		fixture *validatortest.Fixture
+		params  validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_fixture")
+		fixture, err = validatortest.RandomFixture(rand.New(rand.NewSource(1)), 64, 16)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		v.Close()
+		os.RemoveAll(tmp)
+	})
+
+	validate := func() error {
+		store := validatortest.NewMemoryStore()
+		Expect(fixture.Publish(store)).To(Succeed())
+		v = validatortest.NewMemoryValidator(store, params)
+		return v.ValidateTrie(fixture.StateRoot)
+	}
+
+	// Returns an account with storage nodes below the root and code
+	contractAccount := func() common.Address {
+		for _, account := range fixture.Accounts {
+			if len(account.Storage) > 2 && len(account.Code) > 0 {
+				return account.Address
+			}
+		}
+		Fail("no account with storage and code")
+		return common.Address{}
+	}
+
+	It("Builds a complete state", func() {
+		Expect(fixture.StateRoot).ToNot(Equal(types.EmptyRootHash))
+		Expect(validate()).To(Succeed())
+		Expect(v.Progress().Report().Accounts).To(Equal(uint64(len(fixture.Accounts))))
+	})
+	It("Builds the same state from the same accounts", func() {
+		rebuilt, err := validatortest.NewFixture(fixture.Accounts...)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rebuilt.StateRoot).To(Equal(fixture.StateRoot))
+		Expect(rebuilt.Nodes).To(HaveLen(len(fixture.Nodes)))
+	})
+	It("Deletes a state node, which is reported missing at its path", func() {
+		node, ok := fixture.DeleteStateNode([]byte{0x3})
+		Expect(ok).To(BeTrue())
+		err = validate()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+		Expect(err.Error()).To(ContainSubstring("%x", node.Path))
+	})
+	It("Deletes a storage node, which is reported missing", func() {
+		address := contractAccount()
+		Expect(fixture.StorageRoot(address)).ToNot(Equal(types.EmptyRootHash))
+		_, ok := fixture.DeleteStorageNode(address, nil)
+		Expect(ok).To(BeTrue())
+		err = validate()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+	})
+	It("Deletes code, which is reported missing by hash", func() {
+		node, ok := fixture.DeleteCode(contractAccount())
+		Expect(ok).To(BeTrue())
+		Expect(node.Codec).To(Equal(uint64(cid.Raw)))
+		err = validate()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("%x", node.Hash))
+	})
+	It("Publishes into ipld.blocks", func() {
+		sqlite, err := validator.OpenSQLite(filepath.Join(tmp, "blocks.sqlite"), true)
+		Expect(err).ToNot(HaveOccurred())
+		defer sqlite.Close()
+		fixture.BlockNumber = 7
+		Expect(fixture.PublishDB(sqlite)).To(Succeed())
+		Expect(validator.StateRootBlockNumber(sqlite, fixture.StateRoot)).To(Equal(uint64(7)))
+
+		v = validator.NewSQLiteValidator(sqlite, params)
+		Expect(v.ValidateTrie(fixture.StateRoot)).To(Succeed())
+	})
+})
@@ -0,0 +1,160 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// HTTP block source APIs
+const (
+	KuboAPI    = "kubo"    // a kubo node's RPC API, /api/v0/block/get
+	GatewayAPI = "gateway" // a trustless gateway, /ipfs/<cid>?format=raw
+)
+
+// HTTPBlockConfig configures an HTTP block source
+type HTTPBlockConfig struct {
+	URL     string // base URL of the kubo RPC API or the gateway
+	API     string // KuboAPI or GatewayAPI
+	Offline bool   // for kubo, only read blocks the node already has rather than searching the network for them
+	Timeout time.Duration
+}
+
+// HTTPBlockstore fetches raw blocks over HTTP from a kubo node or a trustless gateway
+// Unlike an fsrepo it takes no lock, so it can read from a running node. Fetched blocks are checked against their CIDs.
+type HTTPBlockstore struct {
+	config HTTPBlockConfig
+	client *http.Client
+}
+
+// NewHTTPBlockstore returns a block source for the config
+func NewHTTPBlockstore(config HTTPBlockConfig) (*HTTPBlockstore, error) {
+	if config.API != KuboAPI && config.API != GatewayAPI {
+		return nil, fmt.Errorf("invalid HTTP block API: '%s'", config.API)
+	}
+	if _, err := url.Parse(config.URL); err != nil {
+		return nil, err
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	return &HTTPBlockstore{config: config, client: &http.Client{Timeout: config.Timeout}}, nil
+}
+
+// NewHTTPValidator returns a new trie validator ontop of an HTTP block source
+func NewHTTPValidator(bs *HTTPBlockstore, par Params) *Validator {
+	database := NewBlockstoreDatabase(bs)
+	return newValidator(database, database, bs.config.API, par)
+}
+
+// Get satisfies the BlockGetter interface
+func (b *HTTPBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	req, err := b.request(ctx, "block/get", http.MethodGet, c)
+	if err != nil {
+		return nil, err
+	}
+	data, err := b.do(req, c)
+	if err != nil {
+		return nil, err
+	}
+	got, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !got.Equals(c) {
+		return nil, fmt.Errorf("block fetched for %s has CID %s", c, got)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// Has satisfies the BlockGetter interface
+func (b *HTTPBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	req, err := b.request(ctx, "block/stat", http.MethodHead, c)
+	if err != nil {
+		return false, err
+	}
+	if _, err = b.do(req, c); err != nil {
+		if ipld.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns a request for the block: a POST of the RPC API command to kubo, or a request with the method to the gateway
+func (b *HTTPBlockstore) request(ctx context.Context, command, method string, c cid.Cid) (*http.Request, error) {
+	if b.config.API == KuboAPI {
+		query := url.Values{"arg": {c.String()}}
+		if b.config.Offline {
+			query.Set("offline", "true")
+		}
+		return http.NewRequestWithContext(ctx, http.MethodPost, b.config.URL+"/api/v0/"+command+"?"+query.Encode(), nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.config.URL+"/ipfs/"+c.String()+"?format=raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.raw")
+	return req, nil
+}
+
+// Sends the request, returning the body or ipld.ErrNotFound if the block is missing
+func (b *HTTPBlockstore) do(req *http.Request, c cid.Cid) ([]byte, error) {
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return body, nil
+	}
+	// gateways answer 404 for missing blocks; kubo answers 500 with an error naming the block
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone ||
+		b.config.API == KuboAPI && kuboNotFound(body, c) {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	return nil, fmt.Errorf("fetching %s: %s: %s", c, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// kuboNotFoundOffline is the error kubo returns for a block it does not have, when asked not to search the network
+const kuboNotFoundOffline = "block was not found locally (offline)"
+
+// Returns whether a kubo RPC API error response reports the block as missing
+func kuboNotFound(body []byte, c cid.Cid) bool {
+	var kuboErr struct {
+		Message string
+		Type    string
+	}
+	if err := json.Unmarshal(body, &kuboErr); err != nil || kuboErr.Type != "error" {
+		return false
+	}
+	return kuboErr.Message == kuboNotFoundOffline || kuboErr.Message == ipld.ErrNotFound{Cid: c}.Error()
+}
@@ -0,0 +1,136 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+// Serves blocks by CID as kubo's /api/v0/block/get and block/stat, and as a trustless gateway's /ipfs/<cid>?format=raw
+type blockStandIn struct {
+	blocks map[string][]byte
+	online atomic.Bool // whether a kubo request did not ask for offline mode
+}
+
+func newBlockStandIn(stateNodes, storageNodes [][]byte, contractCode ...[]byte) *blockStandIn {
+	s := &blockStandIn{blocks: make(map[string][]byte)}
+	for _, block := range trieBlocks(stateNodes, storageNodes, contractCode...) {
+		s.blocks[block.Cid().String()] = block.RawData()
+	}
+	return s
+}
+
+func (s *blockStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var key string
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v0/block/"):
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Query().Get("offline") != "true" {
+			s.online.Store(true)
+		}
+		key = r.URL.Query().Get("arg")
+		if _, ok := s.blocks[key]; !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"Message":"block was not found locally (offline)","Code":0,"Type":"error"}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/stat") {
+			w.Write([]byte(`{"Key":"` + key + `"}`))
+			return
+		}
+	case strings.HasPrefix(r.URL.Path, "/ipfs/") && r.URL.Query().Get("format") == "raw":
+		key = strings.TrimPrefix(r.URL.Path, "/ipfs/")
+		if _, ok := s.blocks[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.ipld.raw")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Write(s.blocks[key])
+}
+
+var _ = Describe("HTTP block validator", func() {
+	var (
+		server *httptest.Server
+		params validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_http")
+	})
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmp)
+	})
+
+	serve := func(api string, s *blockStandIn) {
+		server = httptest.NewServer(s)
+		bs, err := validator.NewHTTPBlockstore(validator.HTTPBlockConfig{URL: server.URL + "/", API: api, Offline: true})
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewHTTPValidator(bs, params)
+	}
+
+	for _, api := range []string{validator.KuboAPI, validator.GatewayAPI} {
+		api := api
+		Describe(api, func() {
+			itValidatesStorageTries(func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+				serve(api, newBlockStandIn(stateNodes, storageNodes, contractCode...))
+			})
+			It("Does not ask kubo to fetch blocks from the network", func() {
+				s := newBlockStandIn(trieStateNodes, trieStorageNodes, mockCode)
+				serve(api, s)
+				Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+				Expect(s.online.Load()).To(BeFalse())
+			})
+			It("Returns an error if contract code is missing", func() {
+				serve(api, newBlockStandIn(trieStateNodes, trieStorageNodes))
+				err = v.ValidateTrie(stateRoot)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("code hash"))
+			})
+			It("Returns an error if a block does not match its CID", func() {
+				s := newBlockStandIn(trieStateNodes, trieStorageNodes, mockCode)
+				for key := range s.blocks {
+					s.blocks[key] = []byte("corrupt")
+				}
+				serve(api, s)
+				Expect(v.ValidateTrie(stateRoot)).ToNot(Succeed())
+			})
+		})
+	}
+
+	It("Rejects unknown APIs", func() {
+		server = httptest.NewServer(http.NotFoundHandler())
+		_, err := validator.NewHTTPBlockstore(validator.HTTPBlockConfig{URL: server.URL, API: "ftp"})
+		Expect(err).To(HaveOccurred())
+	})
+})
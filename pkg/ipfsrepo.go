@@ -0,0 +1,188 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	badgerds "github.com/ipfs/go-ds-badger"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// blocksMountpoint is where kubo mounts the datastore holding blocks, when it is separate from the rest of the repo
+const blocksMountpoint = "/blocks"
+
+// OpenIPFSRepo opens the blockstore of a kubo repo directly and read-only, from the datastore spec in the repo config
+// Neither the repo lock nor an IPFS node is taken, so a flatfs blockstore can be read while the daemon is running.
+// Badger takes its own lock on its directory, so a badger blockstore can only be opened while the daemon is stopped.
+func OpenIPFSRepo(repoPath string) (BlockGetter, error) {
+	raw, err := os.ReadFile(filepath.Join(repoPath, "config"))
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Datastore struct {
+			Spec map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("parsing repo config: %w", err)
+	}
+	mountpoint, spec, err := blocksDatastoreSpec(config.Datastore.Spec)
+	if err != nil {
+		return nil, err
+	}
+	path, _ := spec["path"].(string)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repoPath, path)
+	}
+
+	switch spec["type"] {
+	case "flatfs":
+		if mountpoint != blocksMountpoint {
+			return nil, fmt.Errorf("flatfs datastore mounted at %s rather than %s", mountpoint, blocksMountpoint)
+		}
+		// flatfs.Open would clear the daemon's temporary directory, so files are read directly
+		shard, err := flatfs.ReadShardFunc(path)
+		if err != nil {
+			return nil, err
+		}
+		return &flatfsBlockstore{path: path, shard: shard.Func()}, nil
+	case "badgerds":
+		opts := badgerds.DefaultOptions
+		opts.Options = opts.Options.WithReadOnly(true)
+		opts.GcInterval = 0
+		ds, err := badgerds.NewDatastore(path, &opts)
+		if err != nil {
+			if errors.Is(err, badger.ErrReplayNeeded) {
+				err = fmt.Errorf("%w: the badger datastore must be closed cleanly to be read", err)
+			}
+			return nil, err
+		}
+		bs := blockstore.NewBlockstore(ds)
+		if mountpoint == blocksMountpoint {
+			bs = blockstore.NewBlockstoreNoPrefix(ds)
+		}
+		return &badgerBlockstore{bs: bs, ds: ds}, nil
+	default:
+		return nil, fmt.Errorf("unsupported blocks datastore type: %v", spec["type"])
+	}
+}
+
+// NewIPFSRepoValidator returns a new trie validator ontop of a blockstore opened with OpenIPFSRepo
+func NewIPFSRepoValidator(bs BlockGetter, par Params) *Validator {
+	database := NewBlockstoreDatabase(bs)
+	return newValidator(database, database, "ipfs-repo", par)
+}
+
+// Returns the spec of the datastore blocks are stored in, and where it is mounted
+// Kubo mounts a flatfs datastore at /blocks by default, or puts everything in one badger datastore at /
+func blocksDatastoreSpec(spec map[string]interface{}) (string, map[string]interface{}, error) {
+	if spec == nil {
+		return "", nil, fmt.Errorf("no datastore spec in the repo config")
+	}
+	mountpoint := "/"
+	if spec["type"] == "mount" {
+		mounts, _ := spec["mounts"].([]interface{})
+		var found map[string]interface{}
+		for _, m := range mounts {
+			mount, _ := m.(map[string]interface{})
+			if mount["mountpoint"] == blocksMountpoint || (found == nil && mount["mountpoint"] == "/") {
+				found = mount
+				mountpoint, _ = mount["mountpoint"].(string)
+			}
+		}
+		if found == nil {
+			return "", nil, fmt.Errorf("no datastore mounted at %s or / in the repo config", blocksMountpoint)
+		}
+		spec = found
+	}
+	// measure datastores wrap the actual one
+	for spec["type"] == "measure" {
+		child, ok := spec["child"].(map[string]interface{})
+		if !ok {
+			return "", nil, fmt.Errorf("invalid datastore spec: %v", spec)
+		}
+		spec = child
+	}
+	return mountpoint, spec, nil
+}
+
+// flatfsBlockstore reads blocks from the files of a flatfs datastore, without opening it
+type flatfsBlockstore struct {
+	path  string
+	shard flatfs.ShardFunc
+}
+
+// Returns the file a block is stored in, as flatfs names it
+func (f *flatfsBlockstore) file(c cid.Cid) string {
+	key := dshelp.MultihashToDsKey(c.Hash()).String()[1:]
+	return filepath.Join(f.path, f.shard(key), key+".data")
+}
+
+// Get satisfies the BlockGetter interface
+func (f *flatfsBlockstore) Get(_ context.Context, c cid.Cid) (blocks.Block, error) {
+	data, err := os.ReadFile(f.file(c))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ipld.ErrNotFound{Cid: c}
+		}
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// Has satisfies the BlockGetter interface
+func (f *flatfsBlockstore) Has(_ context.Context, c cid.Cid) (bool, error) {
+	_, err := os.Stat(f.file(c))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// badgerBlockstore reads blocks from a read-only badger datastore, which it closes
+type badgerBlockstore struct {
+	bs blockstore.Blockstore
+	ds *badgerds.Datastore
+}
+
+// Get satisfies the BlockGetter interface
+func (b *badgerBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return b.bs.Get(ctx, c)
+}
+
+// Has satisfies the BlockGetter interface
+func (b *badgerBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return b.bs.Has(ctx, c)
+}
+
+// Close satisfies the io.Closer interface
+func (b *badgerBlockstore) Close() error {
+	return b.ds.Close()
+}
@@ -0,0 +1,146 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	badgerds "github.com/ipfs/go-ds-badger"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+// Datastore specs of kubo's default (flatfs) and badgerds profiles
+const (
+	flatfsRepoConfig = `{"Datastore": {"Spec": {"type": "mount", "mounts": [
+		{"mountpoint": "/blocks", "prefix": "flatfs.datastore", "type": "measure",
+			"child": {"path": "blocks", "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2", "sync": true, "type": "flatfs"}},
+		{"mountpoint": "/", "prefix": "leveldb.datastore", "type": "measure",
+			"child": {"compression": "none", "path": "datastore", "type": "levelds"}}]}}}`
+	badgerRepoConfig = `{"Datastore": {"Spec": {"prefix": "badger.datastore", "type": "measure",
+		"child": {"path": "badgerds", "syncWrites": false, "truncate": true, "type": "badgerds"}}}}`
+)
+
+var _ = Describe("IPFS repo validator", func() {
+	var (
+		bs     validator.BlockGetter
+		params validator.Params
+	)
+
+	BeforeEach(func() {
+		params = makeTmp("test_ipfsrepo")
+	})
+	AfterEach(func() {
+		if closer, ok := bs.(io.Closer); ok {
+			closer.Close()
+		}
+		bs = nil
+		os.RemoveAll(tmp)
+	})
+
+	// Writes a kubo repo with a flatfs blockstore, leaving a file in flatfs's temporary directory as a daemon would
+	writeFlatfsRepo := func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+		Expect(os.WriteFile(filepath.Join(tmp, "config"), []byte(flatfsRepoConfig), 0644)).To(Succeed())
+		ds, err := flatfs.CreateOrOpen(filepath.Join(tmp, "blocks"), flatfs.NextToLast(2), false)
+		Expect(err).ToNot(HaveOccurred())
+		putTrie(blockstore.NewBlockstoreNoPrefix(ds), stateNodes, storageNodes, contractCode...)
+		Expect(ds.Close()).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(tmp, "blocks", ".temp", "put-inflight"), nil, 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(tmp, "repo.lock"), nil, 0644)).To(Succeed())
+	}
+
+	writeBadgerRepo := func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+		Expect(os.WriteFile(filepath.Join(tmp, "config"), []byte(badgerRepoConfig), 0644)).To(Succeed())
+		ds, err := badgerds.NewDatastore(filepath.Join(tmp, "badgerds"), nil)
+		Expect(err).ToNot(HaveOccurred())
+		putTrie(blockstore.NewBlockstore(ds), stateNodes, storageNodes, contractCode...)
+		Expect(ds.Close()).To(Succeed())
+	}
+
+	// Returns the contents of the files in the repo, other than the validator's recovery files
+	repoFiles := func() map[string]string {
+		files := make(map[string]string)
+		err := filepath.WalkDir(tmp, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), "recover_") {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			files[path] = string(data)
+			return err
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return files
+	}
+
+	openRepo := func() {
+		bs, err = validator.OpenIPFSRepo(tmp)
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewIPFSRepoValidator(bs, params)
+	}
+
+	for name, writeRepo := range map[string]func([][]byte, [][]byte, ...[]byte){
+		"flatfs": writeFlatfsRepo,
+		"badger": writeBadgerRepo,
+	} {
+		writeRepo := writeRepo
+		Describe(name, func() {
+			itValidatesStorageTries(func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+				writeRepo(stateNodes, storageNodes, contractCode...)
+				openRepo()
+			})
+			It("Leaves the files of the repo unchanged", func() {
+				writeRepo(trieStateNodes, trieStorageNodes, mockCode)
+				before := repoFiles()
+				openRepo()
+				Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+				if closer, ok := bs.(io.Closer); ok {
+					Expect(closer.Close()).To(Succeed())
+				}
+				bs = nil
+				Expect(repoFiles()).To(Equal(before))
+			})
+		})
+	}
+
+	It("Leaves the flatfs datastore and repo lock untouched", func() {
+		writeFlatfsRepo(trieStateNodes, trieStorageNodes, mockCode)
+		openRepo()
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+		Expect(filepath.Join(tmp, "blocks", ".temp", "put-inflight")).To(BeAnExistingFile())
+		Expect(filepath.Join(tmp, "repo.lock")).To(BeAnExistingFile())
+	})
+	It("Opens the badger datastore read-only", func() {
+		writeBadgerRepo(trieStateNodes, trieStorageNodes, mockCode)
+		openRepo()
+		// badger takes a shared directory lock when read-only and an exclusive one otherwise
+		_, err := badgerds.NewDatastore(filepath.Join(tmp, "badgerds"), nil)
+		Expect(err).To(HaveOccurred())
+		opts := badgerds.DefaultOptions
+		opts.Options = opts.Options.WithReadOnly(true)
+		ds, err := badgerds.NewDatastore(filepath.Join(tmp, "badgerds"), &opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ds.Close()).To(Succeed())
+	})
+})
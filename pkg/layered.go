@@ -0,0 +1,182 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+)
+
+// LayeredSource is a named source of a LayeredDatabase
+type LayeredSource struct {
+	Name   string
+	Reader ethdb.KeyValueReader // keyed by CID
+}
+
+// LayeredDatabase looks nodes up by CID in an ordered list of sources, falling back to each source in turn when the
+// ones before it do not have a node. It records which source served each node, so a run answers both whether a root
+// is complete across all the sources and which of them are missing what.
+type LayeredDatabase struct {
+	ethdb.Database
+	sources []LayeredSource
+
+	// a line of the kind, CID and source name is written for every node served by a source other than the first, if set
+	FallbackLog io.Writer
+
+	mu        sync.Mutex
+	served    []map[string]uint64 // by source, then node kind
+	missing   map[string]uint64   // nodes no source has, by kind
+	fallbacks uint64
+}
+
+// LayeredReport summarizes the lookups made through a LayeredDatabase
+type LayeredReport struct {
+	Served    map[string]map[string]uint64 // nodes served, by source name and node kind
+	Missing   map[string]uint64            // nodes missing from every source, by node kind
+	Fallbacks uint64                       // nodes served by a source other than the first
+}
+
+// NewLayeredDatabase returns a database reading from the sources in order
+// The sources' names must be distinct, as the report is keyed by them.
+func NewLayeredDatabase(sources ...LayeredSource) (*LayeredDatabase, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no sources for layered database")
+	}
+	names := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		if names[source.Name] {
+			return nil, fmt.Errorf("duplicate layered source %s", source.Name)
+		}
+		names[source.Name] = true
+	}
+	d := &LayeredDatabase{
+		sources: sources,
+		served:  make([]map[string]uint64, len(sources)),
+		missing: make(map[string]uint64),
+	}
+	for i := range d.served {
+		d.served[i] = make(map[string]uint64)
+	}
+	return d, nil
+}
+
+// NewLayeredValidator returns a new trie validator ontop of a layered database
+func NewLayeredValidator(db *LayeredDatabase, par Params) *Validator {
+	return newValidator(db, db, "layered", par)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// A source failing with an error other than the node being absent fails the lookup, rather than being skipped.
+func (d *LayeredDatabase) Get(key []byte) ([]byte, error) {
+	kind := nodeKind(key)
+	for i, source := range d.sources {
+		value, err := source.Reader.Get(key)
+		if err == nil {
+			if err := d.record(i, key, kind); err != nil {
+				return nil, err
+			}
+			return value, nil
+		}
+		if !isNotFound(err) {
+			return nil, fmt.Errorf("%s: %w", source.Name, err)
+		}
+	}
+	d.mu.Lock()
+	d.missing[kind]++
+	d.mu.Unlock()
+	return nil, fmt.Errorf("%x %w in any of %d sources", key, ErrNotFound, len(d.sources))
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (d *LayeredDatabase) Has(key []byte) (bool, error) {
+	for _, source := range d.sources {
+		if has, err := source.Reader.Has(key); err != nil || has {
+			return has, err
+		}
+	}
+	return false, nil
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *LayeredDatabase) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *LayeredDatabase) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// Close satisfies the io.Closer interface, closing the sources which can be closed and returning the first error
+func (d *LayeredDatabase) Close() error {
+	var err error
+	for _, source := range d.sources {
+		if closer, ok := source.Reader.(io.Closer); ok {
+			if closeErr := closer.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("%s: %w", source.Name, closeErr)
+			}
+		}
+	}
+	return err
+}
+
+// Report returns a summary of the lookups made so far
+func (d *LayeredDatabase) Report() LayeredReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	report := LayeredReport{
+		Served:    make(map[string]map[string]uint64, len(d.sources)),
+		Missing:   make(map[string]uint64, len(d.missing)),
+		Fallbacks: d.fallbacks,
+	}
+	for i, source := range d.sources {
+		served := make(map[string]uint64, len(d.served[i]))
+		for kind, n := range d.served[i] {
+			served[kind] = n
+		}
+		report.Served[source.Name] = served
+	}
+	for kind, n := range d.missing {
+		report.Missing[kind] = n
+	}
+	return report
+}
+
+// Records that the source at index i served the node
+func (d *LayeredDatabase) record(i int, key []byte, kind string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.served[i][kind]++
+	if i == 0 {
+		return nil
+	}
+	d.fallbacks++
+	if d.FallbackLog == nil {
+		return nil
+	}
+	c, err := cid.Cast(key)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.FallbackLog, "%s\t%s\t%s\n", kind, c, d.sources[i].Name)
+	return err
+}
@@ -0,0 +1,142 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("Layered validator", func() {
+	var (
+		primary, secondary ethdb.KeyValueStore
+		fixture            *validatortest.Fixture
+		removed            []validatortest.Node
+		params             validator.Params
+	)
+
+	// Publishes all of the fixture to the secondary store, and all but the removed nodes to the primary
+	BeforeEach(func() {
+		params = makeTmp("test_layered")
+		fixture, err = validatortest.RandomFixture(rand.New(rand.NewSource(3)), 32, 8)
+		Expect(err).ToNot(HaveOccurred())
+		secondary = validatortest.NewMemoryStore()
+		Expect(fixture.Publish(secondary)).To(Succeed())
+
+		removed = nil
+		node, ok := fixture.DeleteStateNode([]byte{0x7})
+		Expect(ok).To(BeTrue())
+		removed = append(removed, node)
+		for _, account := range fixture.Accounts {
+			if len(account.Code) > 0 {
+				node, ok = fixture.DeleteCode(account.Address)
+				Expect(ok).To(BeTrue())
+				removed = append(removed, node)
+				break
+			}
+		}
+		primary = validatortest.NewMemoryStore()
+		Expect(fixture.Publish(primary)).To(Succeed())
+	})
+	AfterEach(func() {
+		v.Close()
+		os.RemoveAll(tmp)
+	})
+
+	open := func(sources ...validator.LayeredSource) *validator.LayeredDatabase {
+		db, err := validator.NewLayeredDatabase(sources...)
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewLayeredValidator(db, params)
+		return db
+	}
+
+	It("Validates a root complete across its sources, reporting the nodes only a fallback has", func() {
+		db := open(
+			validator.LayeredSource{Name: "primary", Reader: primary},
+			validator.LayeredSource{Name: "secondary", Reader: secondary},
+		)
+		var log bytes.Buffer
+		db.FallbackLog = &log
+		Expect(v.ValidateTrie(fixture.StateRoot)).To(Succeed())
+
+		report := db.Report()
+		Expect(report.Missing).To(BeEmpty())
+		Expect(report.Served["primary"]["state"]).ToNot(BeZero())
+		Expect(report.Served["primary"]["storage"]).ToNot(BeZero())
+		Expect(report.Served["secondary"]).To(Equal(map[string]uint64{"state": 1, "code": 1}))
+		Expect(report.Fallbacks).To(BeNumerically("==", len(removed)))
+		var fallback []string
+		for _, n := range removed {
+			kind := "state"
+			if n.Codec == cid.Raw {
+				kind = "code"
+			}
+			fallback = append(fallback, fmt.Sprintf("%s\t%s\tsecondary", kind, n.CID()))
+		}
+		Expect(strings.Split(strings.TrimSpace(log.String()), "\n")).To(ConsistOf(fallback))
+	})
+	It("Reports nodes missing from every source", func() {
+		db := open(validator.LayeredSource{Name: "primary", Reader: primary})
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Or(ContainSubstring("missing trie node"), ContainSubstring("%x", removed[1].Hash)))
+		Expect(db.Report().Missing).ToNot(BeEmpty())
+		Expect(db.Report().Fallbacks).To(BeZero())
+	})
+	It("Fails lookups on source errors, rather than falling back", func() {
+		faulty := validatortest.NewFaultyStore(primary, validatortest.Faults{ErrorRate: 1})
+		open(
+			validator.LayeredSource{Name: "primary", Reader: faulty},
+			validator.LayeredSource{Name: "secondary", Reader: secondary},
+		)
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("primary: " + validatortest.ErrTransient.Error()))
+	})
+	It("Closes its sources", func() {
+		db := open(
+			validator.LayeredSource{Name: "primary", Reader: primary},
+			validator.LayeredSource{Name: "secondary", Reader: secondary},
+		)
+		Expect(db.Close()).To(Succeed())
+		_, err := secondary.Get(fixture.Nodes[0].CID().Bytes())
+		Expect(err).To(HaveOccurred())
+	})
+	It("Requires a source", func() {
+		_, err := validator.NewLayeredDatabase()
+		Expect(err).To(HaveOccurred())
+	})
+	It("Requires distinct source names", func() {
+		store := validatortest.NewMemoryStore()
+		_, err := validator.NewLayeredDatabase(
+			validator.LayeredSource{Name: "memory", Reader: store},
+			validator.LayeredSource{Name: "memory", Reader: store},
+		)
+		Expect(err).To(MatchError(ContainSubstring("duplicate layered source memory")))
+	})
+})
@@ -0,0 +1,134 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	tryAdvisoryLockPgStr = "SELECT pg_try_advisory_lock(hashtextextended($1, 0))"
+	advisoryUnlockPgStr  = "SELECT pg_advisory_unlock(hashtextextended($1, 0))"
+	putLockHolderPgStr   = `INSERT INTO validator_recovery_lock (root, traversal_type, pid, hostname) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (root, traversal_type) DO UPDATE SET (pid, hostname, acquired_at) = (EXCLUDED.pid, EXCLUDED.hostname, now())`
+	getLockHolderPgStr    = "SELECT pid, hostname FROM validator_recovery_lock WHERE root = $1 AND traversal_type = $2"
+	deleteLockHolderPgStr = "DELETE FROM validator_recovery_lock WHERE root = $1 AND traversal_type = $2"
+)
+
+// RecoveryLockedError is returned when the recovery state for a traversal is locked by another process
+type RecoveryLockedError struct {
+	Location string
+	PID      int
+	Host     string
+}
+
+func (e *RecoveryLockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("recovery state %s is locked by another process", e.Location)
+	}
+	return fmt.Sprintf("recovery state %s is locked by pid %d on host %s", e.Location, e.PID, e.Host)
+}
+
+// Takes a non-blocking flock on the lock file and records this process as the holder
+func lockFile(path string) (func() error, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			lockErr := &RecoveryLockedError{Location: path}
+			fmt.Fscanf(file, "%d %s", &lockErr.PID, &lockErr.Host)
+			return nil, lockErr
+		}
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(file, "%d %s\n", os.Getpid(), host); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() error {
+		// the lock file is left in place; removing it would let another process lock a new file
+		// while a third still holds the old one
+		file.Truncate(0)
+		file.Seek(0, io.SeekStart)
+		return file.Close()
+	}, nil
+}
+
+// Takes a session level advisory lock for the root and traversal type and records this process as the holder
+func lockAdvisory(db *sqlx.DB, root common.Hash, traversal TraversalType) (func() error, error) {
+	key := fmt.Sprintf("validator_recovery:%s:%s", root.Hex(), traversal)
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, tryAdvisoryLockPgStr, key); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !locked {
+		defer conn.Close()
+		lockErr := &RecoveryLockedError{Location: fmt.Sprintf("validator_recovery(%s, %s)", root.Hex(), traversal)}
+		var holder struct {
+			PID  int    `db:"pid"`
+			Host string `db:"hostname"`
+		}
+		err := conn.GetContext(ctx, &holder, getLockHolderPgStr, root.Hex(), traversal)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		lockErr.PID, lockErr.Host = holder.PID, holder.Host
+		return nil, lockErr
+	}
+
+	host, _ := os.Hostname()
+	if _, err := conn.ExecContext(ctx, putLockHolderPgStr, root.Hex(), traversal, os.Getpid(), host); err != nil {
+		conn.ExecContext(ctx, advisoryUnlockPgStr, key)
+		conn.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, deleteLockHolderPgStr, root.Hex(), traversal); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, advisoryUnlockPgStr, key)
+		return err
+	}, nil
+}
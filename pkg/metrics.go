@@ -0,0 +1,200 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const metricsNamespace = "eth_ipfs_state_validator"
+
+// Node kinds, derived from the codec of the CID a node is fetched by
+const (
+	stateNodeKind   = "state"
+	storageNodeKind = "storage"
+	codeKind        = "code"
+	unknownKind     = "unknown"
+)
+
+var (
+	nodesFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "nodes_fetched_total",
+		Help:      "Nodes and code fetched from the backend, by kind",
+	}, []string{"backend", "kind"})
+	missingNodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "missing_nodes_total",
+		Help:      "Nodes and code not found in the backend, by kind",
+	}, []string{"backend", "kind"})
+	backendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_errors_total",
+		Help:      "Backend lookups which failed for reasons other than a missing node",
+	}, []string{"backend"})
+	fetchLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "node_fetch_seconds",
+		Help:      "Latency of node and code lookups",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10), // 100µs to ~26s
+	}, []string{"backend"})
+	repairedNodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "repaired_nodes_total",
+		Help:      "Missing nodes and code filled in from the repair source, by kind",
+	}, []string{"kind"})
+	activeWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "active_workers",
+		Help:      "Workers currently traversing a subtrie",
+	})
+)
+
+// RegisterMetrics registers the validator's metrics with a registerer
+// Progress and cache metrics are read from the given validator
+func RegisterMetrics(reg prometheus.Registerer, v *Validator) error {
+	collectors := []prometheus.Collector{
+		nodesFetched, missingNodes, backendErrors, fetchLatency, repairedNodes, activeWorkers,
+		progressGauge("progress_ratio", "Approximate fraction of the key space covered", v,
+			func(r ProgressReport) float64 { return r.Coverage }),
+		progressGauge("progress_nodes", "State and storage nodes visited in the current traversal", v,
+			func(r ProgressReport) float64 { return float64(r.Nodes) }),
+		progressGauge("progress_accounts", "Accounts validated in the current traversal", v,
+			func(r ProgressReport) float64 { return float64(r.Accounts) }),
+		progressGauge("progress_storage_tries", "Storage tries validated in the current traversal", v,
+			func(r ProgressReport) float64 { return float64(r.StorageTries) }),
+		progressGauge("eta_seconds", "Estimated time until the current traversal completes", v,
+			func(r ProgressReport) float64 { return r.ETA.Seconds() }),
+		&cacheCollector{v: v},
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func progressGauge(name, help string, v *Validator, value func(ProgressReport) float64) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      name,
+		Help:      help,
+	}, func() float64 {
+		if p := v.Progress(); p != nil {
+			return value(p.Report())
+		}
+		return 0
+	})
+}
+
+var cacheStatsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metricsNamespace, "groupcache", "stats_total"),
+	"Groupcache statistics of the Postgres backend",
+	[]string{"stat"}, nil,
+)
+
+// cacheCollector exports the groupcache stats exposed by GetCacheStats
+type cacheCollector struct {
+	v *Validator
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheStatsDesc
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.v.GetCacheStats()
+	for stat, value := range map[string]int64{
+		"gets":            stats.Gets.Get(),
+		"cache_hits":      stats.CacheHits.Get(),
+		"peer_loads":      stats.PeerLoads.Get(),
+		"peer_errors":     stats.PeerErrors.Get(),
+		"loads":           stats.Loads.Get(),
+		"loads_deduped":   stats.LoadsDeduped.Get(),
+		"local_loads":     stats.LocalLoads.Get(),
+		"local_load_errs": stats.LocalLoadErrs.Get(),
+		"server_requests": stats.ServerRequests.Get(),
+	} {
+		ch <- prometheus.MustNewConstMetric(cacheStatsDesc, prometheus.CounterValue, float64(value), stat)
+	}
+}
+
+// instrumentedDatabase records metrics for the lookups made through an ethdb.Database keyed by CID
+type instrumentedDatabase struct {
+	ethdb.Database
+	backend   string
+	slowFetch func(key []byte, elapsed time.Duration) // called after every lookup, if set
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (d *instrumentedDatabase) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	val, err := d.Database.Get(key)
+	elapsed := time.Since(start)
+	fetchLatency.WithLabelValues(d.backend).Observe(elapsed.Seconds())
+	if d.slowFetch != nil {
+		d.slowFetch(key, elapsed)
+	}
+
+	kind := nodeKind(key)
+	switch {
+	case err == nil:
+		nodesFetched.WithLabelValues(d.backend, kind).Inc()
+	case isNotFound(err):
+		missingNodes.WithLabelValues(d.backend, kind).Inc()
+	default:
+		backendErrors.WithLabelValues(d.backend).Inc()
+	}
+	return val, err
+}
+
+// Returns the kind of node a CID key refers to
+func nodeKind(key []byte) string {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return unknownKind
+	}
+	switch c.Type() {
+	case cid.EthStateTrie:
+		return stateNodeKind
+	case cid.EthStorageTrie:
+		return storageNodeKind
+	case cid.Raw:
+		return codeKind
+	default:
+		return unknownKind
+	}
+}
+
+// ErrNotFound is returned, possibly wrapped, by backends which have no error of their own for an absent key
+var ErrNotFound = errors.New("not found")
+
+// Returns whether a backend error means the key is absent, as opposed to the lookup having failed
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, sql.ErrNoRows) || ipld.IsNotFound(err) ||
+		errors.Is(err, leveldb.ErrNotFound) || errors.Is(err, pebble.ErrNotFound)
+}
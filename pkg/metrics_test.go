@@ -0,0 +1,75 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var _ = Describe("Metrics", Label("postgres"), func() {
+	var reg *prometheus.Registry
+
+	BeforeEach(func() {
+		db, err = openTestDB()
+		Expect(err).ToNot(HaveOccurred())
+		params := makeTmp("test_metrics")
+		v = validator.NewPGIPFSValidator(db, params)
+		reg = prometheus.NewRegistry()
+		err = validator.RegisterMetrics(reg, v)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		err = ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		os.RemoveAll(tmp)
+		v.Close()
+		db.Close()
+	})
+
+	It("Counts fetched nodes by kind and reports progress", func() {
+		before := gatherMetrics(reg)
+		loadTrie(trieStateNodes, trieStorageNodes, mockCode)
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).ToNot(HaveOccurred())
+
+		after := gatherMetrics(reg)
+		fetched := `eth_ipfs_state_validator_nodes_fetched_total{backend="postgres",kind="%s"}`
+		Expect(after[fmt.Sprintf(fetched, "state")] - before[fmt.Sprintf(fetched, "state")]).To(BeNumerically(">=", len(trieStateNodes)))
+		Expect(after[fmt.Sprintf(fetched, "storage")] - before[fmt.Sprintf(fetched, "storage")]).To(BeNumerically(">=", len(trieStorageNodes)))
+		Expect(after[fmt.Sprintf(fetched, "code")] - before[fmt.Sprintf(fetched, "code")]).To(BeNumerically("==", 1))
+		Expect(after["eth_ipfs_state_validator_progress_ratio"]).To(BeNumerically("~", 1))
+		Expect(after["eth_ipfs_state_validator_active_workers"]).To(BeNumerically("==", 0))
+		Expect(after[`eth_ipfs_state_validator_groupcache_stats_total{stat="gets"}`]).To(BeNumerically(">", 0))
+	})
+	It("Counts missing nodes", func() {
+		before := gatherMetrics(reg)
+		loadTrie(missingNodeStateNodes, trieStorageNodes, mockCode)
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).To(HaveOccurred())
+
+		after := gatherMetrics(reg)
+		missing := `eth_ipfs_state_validator_missing_nodes_total{backend="postgres",kind="state"}`
+		Expect(after[missing] - before[missing]).To(BeNumerically(">=", 1))
+	})
+})
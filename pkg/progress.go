@@ -0,0 +1,253 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cerc-io/ipld-eth-statedb/trie_by_cid/trie"
+	"github.com/mattn/go-isatty"
+	log "github.com/sirupsen/logrus"
+)
+
+const progressBarWidth = 40
+
+// Progress tracks how much of the key space a traversal has covered
+// Coverage is estimated from the path of each worker's iterator relative to the range of paths it was assigned
+type Progress struct {
+	started time.Time
+
+	nodes        atomic.Uint64
+	accounts     atomic.Uint64
+	storageTries atomic.Uint64
+
+	mu      sync.RWMutex
+	workers []*workerProgress
+}
+
+type workerProgress struct {
+	start, end float64
+	position   atomic.Uint64 // float64 bits
+}
+
+// ProgressReport is a point-in-time summary of a traversal's progress
+type ProgressReport struct {
+	Coverage     float64   // approximate fraction of the key space covered, including any recovered progress
+	Workers      []float64 // approximate fraction of each worker's range covered
+	Nodes        uint64    // state and storage nodes visited in this run
+	Accounts     uint64
+	StorageTries uint64
+	Elapsed      time.Duration
+	NodesPerSec  float64
+	ETA          time.Duration // zero if it cannot be estimated yet
+}
+
+func newProgress() *Progress {
+	return &Progress{started: time.Now()}
+}
+
+// Report returns a summary of the current progress
+func (p *Progress) Report() ProgressReport {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.report()
+}
+
+func (p *Progress) report() ProgressReport {
+	report := ProgressReport{
+		Coverage:     1,
+		Workers:      make([]float64, len(p.workers)),
+		Nodes:        p.nodes.Load(),
+		Accounts:     p.accounts.Load(),
+		StorageTries: p.storageTries.Load(),
+		Elapsed:      time.Since(p.started),
+	}
+	// ranges are disjoint and cover the key space, except for ranges finished in a previous run
+	baseline := 1.0
+	for i, w := range p.workers {
+		pos := math.Float64frombits(w.position.Load())
+		report.Coverage -= w.end - pos
+		baseline -= w.end - w.start
+		if w.end > w.start {
+			report.Workers[i] = (pos - w.start) / (w.end - w.start)
+		} else {
+			report.Workers[i] = 1
+		}
+	}
+	if report.Coverage < 0 {
+		report.Coverage = 0
+	}
+
+	seconds := report.Elapsed.Seconds()
+	if seconds > 0 {
+		report.NodesPerSec = float64(report.Nodes) / seconds
+	}
+	if covered := report.Coverage - baseline; covered > 0 && seconds > 0 {
+		remaining := (1 - report.Coverage) / (covered / seconds)
+		report.ETA = time.Duration(remaining * float64(time.Second)).Round(time.Second)
+	}
+	return report
+}
+
+// Wraps a worker's iterator so that its position is tracked
+func (p *Progress) trackWorker(it trie.NodeIterator) trie.NodeIterator {
+	w := &workerProgress{end: 1}
+	if bounded, ok := it.(interface{ Bounds() ([]byte, []byte) }); ok {
+		start, end := bounded.Bounds()
+		w.start = pathPosition(start)
+		if end != nil {
+			w.end = pathPosition(end)
+		}
+	}
+	w.position.Store(math.Float64bits(w.start))
+
+	p.mu.Lock()
+	p.workers = append(p.workers, w)
+	p.mu.Unlock()
+	return &progressIterator{NodeIterator: it, progress: p, worker: w}
+}
+
+// Wraps a storage trie iterator so that its nodes are counted
+func (p *Progress) trackStorage(it trie.NodeIterator) trie.NodeIterator {
+	return &progressIterator{NodeIterator: it, progress: p}
+}
+
+// Starts logging the progress at the given interval, and whenever SIGUSR1 is received
+// If bar is set and stderr is a terminal, a progress bar is drawn instead of the periodic log lines
+// Returns a function which stops reporting and logs a final summary
+func (p *Progress) start(interval time.Duration, bar bool) func() {
+	bar = bar && isatty.IsTerminal(os.Stderr.Fd())
+	if bar {
+		interval = time.Second
+	}
+	var ticker *time.Ticker
+	var tick <-chan time.Time // never fires if periodic reporting is disabled
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		tick = ticker.C
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if ticker != nil {
+			defer ticker.Stop()
+		}
+		for {
+			select {
+			case <-tick:
+				if bar {
+					p.drawBar()
+				} else {
+					p.logReport()
+				}
+			case <-sigChan:
+				p.dump()
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(quit)
+		<-done
+		if bar {
+			p.drawBar()
+			fmt.Fprintln(os.Stderr)
+		}
+		if interval > 0 {
+			p.logReport()
+		}
+	}
+}
+
+func (p *Progress) logReport() {
+	r := p.Report()
+	workers := make([]string, len(r.Workers))
+	for i, w := range r.Workers {
+		workers[i] = fmt.Sprintf("%.1f%%", w*100)
+	}
+	log.WithFields(log.Fields{
+		"workers":       strings.Join(workers, " "),
+		"nodes/s":       fmt.Sprintf("%.0f", r.NodesPerSec),
+		"accounts":      r.Accounts,
+		"storage tries": r.StorageTries,
+		"eta":           formatETA(r.ETA),
+	}).Infof("Progress: %.2f%% covered, %d nodes in %s", r.Coverage*100, r.Nodes, r.Elapsed.Round(time.Second))
+}
+
+func (p *Progress) drawBar() {
+	r := p.Report()
+	filled := int(r.Coverage * progressBarWidth)
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %6.2f%% %8.0f nodes/s  ETA %s   ",
+		strings.Repeat("#", filled), strings.Repeat("-", progressBarWidth-filled),
+		r.Coverage*100, r.NodesPerSec, formatETA(r.ETA))
+}
+
+// Logs the full status, including each worker's position
+func (p *Progress) dump() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	r := p.report()
+	log.Infof("Status: %.2f%% covered, %d nodes, %d accounts, %d storage tries in %s (%.0f nodes/s), ETA %s",
+		r.Coverage*100, r.Nodes, r.Accounts, r.StorageTries, r.Elapsed.Round(time.Second), r.NodesPerSec, formatETA(r.ETA))
+	for i, w := range p.workers {
+		log.Infof("Worker %d: %.2f%% of range [%.4f, %.4f), at %.6f",
+			i, r.Workers[i]*100, w.start, w.end, math.Float64frombits(w.position.Load()))
+	}
+}
+
+func formatETA(eta time.Duration) string {
+	if eta == 0 {
+		return "unknown"
+	}
+	return eta.String()
+}
+
+// progressIterator updates a Progress as the wrapped iterator advances
+type progressIterator struct {
+	trie.NodeIterator
+	progress *Progress
+	worker   *workerProgress // nil for storage iterators
+}
+
+func (it *progressIterator) Next(descend bool) bool {
+	if !it.NodeIterator.Next(descend) {
+		if it.worker != nil && it.NodeIterator.Error() == nil {
+			it.worker.position.Store(math.Float64bits(it.worker.end))
+		}
+		return false
+	}
+	it.progress.nodes.Add(1)
+	if it.worker != nil {
+		it.worker.position.Store(math.Float64bits(pathPosition(it.Path())))
+	}
+	return true
+}
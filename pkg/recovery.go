@@ -0,0 +1,315 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	createRecoveryTablePgStr = `CREATE TABLE IF NOT EXISTS validator_recovery (
+		root           VARCHAR(66) NOT NULL,
+		traversal_type VARCHAR(16) NOT NULL,
+		recovery_state TEXT NOT NULL,
+		updated_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (root, traversal_type)
+	)`
+	getRecoveryPgStr = "SELECT recovery_state FROM validator_recovery WHERE root = $1 AND traversal_type = $2"
+	putRecoveryPgStr = `INSERT INTO validator_recovery (root, traversal_type, recovery_state) VALUES ($1, $2, $3)
+		ON CONFLICT (root, traversal_type) DO UPDATE SET (recovery_state, updated_at) = (EXCLUDED.recovery_state, now())`
+	deleteRecoveryPgStr = "DELETE FROM validator_recovery WHERE root = $1 AND traversal_type = $2"
+	listRecoveryPgStr   = "SELECT root, traversal_type, recovery_state, updated_at FROM validator_recovery ORDER BY updated_at"
+
+	createRecoveryLockTablePgStr = `CREATE TABLE IF NOT EXISTS validator_recovery_lock (
+		root           VARCHAR(66) NOT NULL,
+		traversal_type VARCHAR(16) NOT NULL,
+		pid            INTEGER NOT NULL,
+		hostname       TEXT NOT NULL,
+		acquired_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (root, traversal_type)
+	)`
+)
+
+// TraversalTypes lists the traversal types recovery state can be saved for
+var TraversalTypes = []TraversalType{fullTraversal, stateTraversal, storageTraversal, copyTraversal}
+
+// RecoveryStore persists iterator tracker state between runs
+// The tracker always works against a local recovery file; a store populates that file before a traversal
+// and persists it once the traversal stops
+type RecoveryStore interface {
+	// Fetch writes any saved state for the root and traversal type to the local recovery file
+	Fetch(root common.Hash, traversal TraversalType, file string) error
+	// Store persists the local recovery file, or clears the saved state if the file does not exist
+	Store(root common.Hash, traversal TraversalType, file string) error
+	// List returns all saved state
+	List() ([]RecoveryRecord, error)
+	// Clear deletes the saved state described by a record
+	Clear(record RecoveryRecord) error
+	// Lock takes an exclusive lock on the saved state for the root and traversal type, failing with a
+	// RecoveryLockedError if another process holds it
+	Lock(root common.Hash, traversal TraversalType, file string) (unlock func() error, err error)
+}
+
+// RecoveryRecord describes the saved state of an interrupted traversal
+type RecoveryRecord struct {
+	Root      common.Hash // zero for recovery files, which are keyed by traversal type only
+	Traversal TraversalType
+	Location  string
+	Updated   time.Time
+	Positions []IteratorPosition
+}
+
+// IteratorPosition is the saved position of a single unfinished iterator
+type IteratorPosition struct {
+	Path    []byte // hex nibble path of the last node visited
+	EndPath []byte // upper bound of the iterator's range, nil if it runs to the end of the trie
+}
+
+// Remaining returns the approximate fraction of the key space the iterator has yet to cover
+func (p IteratorPosition) Remaining() float64 {
+	end := 1.0
+	if p.EndPath != nil {
+		end = pathPosition(p.EndPath)
+	}
+	if rem := end - pathPosition(p.Path); rem > 0 {
+		return rem
+	}
+	return 0
+}
+
+// Progress returns the approximate fraction of the trie that was covered when the state was saved
+// Iterators are created over ranges which partition the key space, and finished iterators are not saved,
+// so everything outside of the saved iterators' remaining ranges has been covered
+func (r RecoveryRecord) Progress() float64 {
+	progress := 1.0
+	for _, pos := range r.Positions {
+		progress -= pos.Remaining()
+	}
+	if progress < 0 {
+		return 0
+	}
+	return progress
+}
+
+// Returns the approximate fraction of the key space which precedes a hex nibble path in a pre-order traversal
+func pathPosition(path []byte) float64 {
+	pos, scale := 0.0, 1.0
+	for _, nibble := range path {
+		if nibble > 0xf { // terminator
+			break
+		}
+		scale /= 16
+		pos += float64(nibble) * scale
+	}
+	return pos
+}
+
+// Parses the CSV rows written by the iterator tracker
+func parseRecoveryState(state []byte) ([]IteratorPosition, error) {
+	in := csv.NewReader(bytes.NewReader(state))
+	in.FieldsPerRecord = 2
+	rows, err := in.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	positions := make([]IteratorPosition, 0, len(rows))
+	for _, row := range rows {
+		var pos IteratorPosition
+		if pos.Path, err = hex.DecodeString(row[0]); err != nil {
+			return nil, err
+		}
+		if len(row[1]) != 0 {
+			if pos.EndPath, err = hex.DecodeString(row[1]); err != nil {
+				return nil, err
+			}
+		}
+		positions = append(positions, pos)
+	}
+	return positions, nil
+}
+
+// FileRecoveryStore keeps recovery state in the local recovery files only
+type FileRecoveryStore struct {
+	Format string // %s substituted with traversal type
+}
+
+var _ RecoveryStore = FileRecoveryStore{}
+
+// Fetch satisfies the RecoveryStore interface; the local file is already the saved state
+func (FileRecoveryStore) Fetch(common.Hash, TraversalType, string) error { return nil }
+
+// Store satisfies the RecoveryStore interface; the tracker has already written the local file
+func (FileRecoveryStore) Store(common.Hash, TraversalType, string) error { return nil }
+
+// List satisfies the RecoveryStore interface
+func (s FileRecoveryStore) List() ([]RecoveryRecord, error) {
+	var records []RecoveryRecord
+	for _, traversal := range TraversalTypes {
+		file := fmt.Sprintf(s.Format, traversal)
+		info, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		state, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		positions, err := parseRecoveryState(state)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recovery file %s: %w", file, err)
+		}
+		records = append(records, RecoveryRecord{
+			Traversal: traversal,
+			Location:  file,
+			Updated:   info.ModTime(),
+			Positions: positions,
+		})
+	}
+	return records, nil
+}
+
+// Clear satisfies the RecoveryStore interface
+func (s FileRecoveryStore) Clear(record RecoveryRecord) error {
+	return removeIfExists(fmt.Sprintf(s.Format, record.Traversal))
+}
+
+// Lock satisfies the RecoveryStore interface
+// The lock is a flock on a lock file next to the recovery file, since the tracker replaces the recovery file itself
+func (FileRecoveryStore) Lock(_ common.Hash, _ TraversalType, file string) (func() error, error) {
+	return lockFile(file + ".lock")
+}
+
+// PGRecoveryStore keeps recovery state in a table of the Postgres database, so that it outlives the local filesystem
+type PGRecoveryStore struct {
+	db *sqlx.DB
+}
+
+var _ RecoveryStore = &PGRecoveryStore{}
+
+// NewPGRecoveryStore returns a RecoveryStore ontop of a Postgres connection pool, creating its table if necessary
+func NewPGRecoveryStore(db *sqlx.DB) (*PGRecoveryStore, error) {
+	for _, stmt := range []string{createRecoveryTablePgStr, createRecoveryLockTablePgStr} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &PGRecoveryStore{db: db}, nil
+}
+
+// Fetch satisfies the RecoveryStore interface
+// Any stale local file is removed if there is no saved state for the root and traversal type
+func (s *PGRecoveryStore) Fetch(root common.Hash, traversal TraversalType, file string) error {
+	var state string
+	err := s.db.Get(&state, getRecoveryPgStr, root.Hex(), traversal)
+	if err == sql.ErrNoRows {
+		return removeIfExists(file)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(state), 0644)
+}
+
+// Store satisfies the RecoveryStore interface
+func (s *PGRecoveryStore) Store(root common.Hash, traversal TraversalType, file string) error {
+	state, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		_, err = s.db.Exec(deleteRecoveryPgStr, root.Hex(), traversal)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(putRecoveryPgStr, root.Hex(), traversal, string(state))
+	return err
+}
+
+// List satisfies the RecoveryStore interface
+func (s *PGRecoveryStore) List() ([]RecoveryRecord, error) {
+	var rows []struct {
+		Root      string    `db:"root"`
+		Traversal string    `db:"traversal_type"`
+		State     string    `db:"recovery_state"`
+		Updated   time.Time `db:"updated_at"`
+	}
+	if err := s.db.Select(&rows, listRecoveryPgStr); err != nil {
+		return nil, err
+	}
+	records := make([]RecoveryRecord, 0, len(rows))
+	for _, row := range rows {
+		positions, err := parseRecoveryState([]byte(row.State))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recovery state for root %s (%s): %w", row.Root, row.Traversal, err)
+		}
+		records = append(records, RecoveryRecord{
+			Root:      common.HexToHash(row.Root),
+			Traversal: row.Traversal,
+			Location:  "validator_recovery",
+			Updated:   row.Updated,
+			Positions: positions,
+		})
+	}
+	return records, nil
+}
+
+// Clear satisfies the RecoveryStore interface
+func (s *PGRecoveryStore) Clear(record RecoveryRecord) error {
+	_, err := s.db.Exec(deleteRecoveryPgStr, record.Root.Hex(), record.Traversal)
+	return err
+}
+
+// Lock satisfies the RecoveryStore interface
+// The lock is a session level advisory lock, held on a dedicated connection until unlocked. The local file is keyed by
+// traversal type alone, so it is flocked as well, against a run on the same host validating another root.
+func (s *PGRecoveryStore) Lock(root common.Hash, traversal TraversalType, file string) (func() error, error) {
+	unlockAdvisory, err := lockAdvisory(s.db, root, traversal)
+	if err != nil {
+		return nil, err
+	}
+	unlockFile, err := lockFile(file + ".lock")
+	if err != nil {
+		unlockAdvisory()
+		return nil, err
+	}
+	return func() error {
+		fileErr := unlockFile()
+		if err := unlockAdvisory(); err != nil {
+			return err
+		}
+		return fileErr
+	}, nil
+}
+
+func removeIfExists(file string) error {
+	err := os.Remove(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
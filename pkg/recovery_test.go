@@ -0,0 +1,201 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var _ = Describe("File recovery store", func() {
+	var store validator.FileRecoveryStore
+
+	BeforeEach(func() {
+		tmp, err = os.MkdirTemp("", "test_recovery")
+		Expect(err).ToNot(HaveOccurred())
+		store = validator.FileRecoveryStore{Format: filepath.Join(tmp, "recover_%s")}
+	})
+	AfterEach(func() {
+		os.RemoveAll(tmp)
+	})
+
+	It("Lists recovery files with their saved positions", func() {
+		// two of four workers remain: one halfway through [0, 4), one just started on [c, end)
+		err = os.WriteFile(filepath.Join(tmp, "recover_state"), []byte("02,04\n0c00,\n"), 0644)
+		Expect(err).ToNot(HaveOccurred())
+
+		records, err := store.List()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].Traversal).To(Equal("state"))
+		Expect(records[0].Location).To(Equal(filepath.Join(tmp, "recover_state")))
+		Expect(records[0].Positions).To(Equal([]validator.IteratorPosition{
+			{Path: []byte{0x2}, EndPath: []byte{0x4}},
+			{Path: []byte{0xc, 0x0}},
+		}))
+		Expect(records[0].Progress()).To(BeNumerically("~", 0.625))
+	})
+	It("Clears recovery files", func() {
+		err = os.WriteFile(filepath.Join(tmp, "recover_full"), []byte("02,04\n"), 0644)
+		Expect(err).ToNot(HaveOccurred())
+		records, err := store.List()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+
+		err = store.Clear(records[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filepath.Join(tmp, "recover_full")).ToNot(BeAnExistingFile())
+	})
+	It("Locks recovery files exclusively", func() {
+		recoveryFile := filepath.Join(tmp, "recover_full")
+		unlock, err := store.Lock(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = store.Lock(stateRoot, "full", recoveryFile)
+		var lockErr *validator.RecoveryLockedError
+		Expect(errors.As(err, &lockErr)).To(BeTrue())
+		Expect(lockErr.PID).To(Equal(os.Getpid()))
+		unlockOther, err := store.Lock(stateRoot, "state", filepath.Join(tmp, "recover_state"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unlockOther()).To(Succeed())
+
+		err = unlock()
+		Expect(err).ToNot(HaveOccurred())
+		unlock, err = store.Lock(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unlock()).To(Succeed())
+	})
+})
+
+var _ = Describe("PG recovery store", Label("postgres"), func() {
+	var (
+		store        *validator.PGRecoveryStore
+		recoveryFile string
+		savedState   = []byte("0a0b,0c\n0c0d,\n")
+	)
+
+	BeforeEach(func() {
+		db, err = openTestDB()
+		Expect(err).ToNot(HaveOccurred())
+		store, err = validator.NewPGRecoveryStore(db)
+		Expect(err).ToNot(HaveOccurred())
+		tmp, err = os.MkdirTemp("", "test_recovery")
+		Expect(err).ToNot(HaveOccurred())
+		recoveryFile = filepath.Join(tmp, "recover_full")
+	})
+	AfterEach(func() {
+		_, err = db.Exec("TRUNCATE validator_recovery")
+		Expect(err).ToNot(HaveOccurred())
+		os.RemoveAll(tmp)
+		db.Close()
+	})
+
+	It("Restores saved state to the local recovery file", func() {
+		err = os.WriteFile(recoveryFile, savedState, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		err = store.Store(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = os.Remove(recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		err = store.Fetch(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		restored, err := os.ReadFile(recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restored).To(Equal(savedState))
+	})
+	It("Keys saved state by root and traversal type", func() {
+		err = os.WriteFile(recoveryFile, savedState, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		err = store.Store(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = store.Fetch(stateRoot, "state", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recoveryFile).ToNot(BeAnExistingFile())
+		err = store.Fetch(storageRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recoveryFile).ToNot(BeAnExistingFile())
+	})
+	It("Lists saved state by root and traversal type", func() {
+		err = os.WriteFile(recoveryFile, savedState, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		err = store.Store(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		records, err := store.List()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].Root).To(Equal(stateRoot))
+		Expect(records[0].Traversal).To(Equal("full"))
+		Expect(records[0].Positions).To(HaveLen(2))
+
+		err = store.Clear(records[0])
+		Expect(err).ToNot(HaveOccurred())
+		records, err = store.List()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(BeEmpty())
+	})
+	It("Locks saved state exclusively", func() {
+		unlock, err := store.Lock(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = store.Lock(stateRoot, "full", recoveryFile)
+		var lockErr *validator.RecoveryLockedError
+		Expect(errors.As(err, &lockErr)).To(BeTrue())
+		Expect(lockErr.PID).To(Equal(os.Getpid()))
+		unlockOther, err := store.Lock(storageRoot, "full", filepath.Join(tmp, "recover_other_full"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unlockOther()).To(Succeed())
+
+		err = unlock()
+		Expect(err).ToNot(HaveOccurred())
+		unlock, err = store.Lock(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unlock()).To(Succeed())
+	})
+	It("Locks the local recovery file against other roots", func() {
+		unlock, err := store.Lock(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		defer unlock()
+
+		_, err = store.Lock(storageRoot, "full", recoveryFile)
+		var lockErr *validator.RecoveryLockedError
+		Expect(errors.As(err, &lockErr)).To(BeTrue())
+		Expect(lockErr.Location).To(Equal(recoveryFile + ".lock"))
+	})
+	It("Clears saved state once the local recovery file is removed", func() {
+		err = os.WriteFile(recoveryFile, savedState, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		err = store.Store(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = os.Remove(recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		err = store.Store(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		err = store.Fetch(stateRoot, "full", recoveryFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recoveryFile).ToNot(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,114 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v0"
+)
+
+// Repairer fills in nodes and code missing from a validator's backend from a secondary source
+type Repairer struct {
+	Source ethdb.KeyValueReader // keyed by CID
+	Dest   ethdb.KeyValueWriter // keyed by CID, e.g. from NewPGIPFSWriter
+	Log    io.Writer            // a line is written for every repaired key, if set
+
+	mu       sync.Mutex
+	repaired []cid.Cid
+}
+
+// Repaired returns the keys repaired so far
+func (r *Repairer) Repaired() []cid.Cid {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]cid.Cid(nil), r.repaired...)
+}
+
+// Fetches a missing key from the source, checks it against the hash in the key, and writes it to the destination
+func (r *Repairer) repair(key []byte) ([]byte, error) {
+	c, hash, err := keyToHash(key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := r.Source.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s from repair source: %w", c, err)
+	}
+	if got := crypto.Keccak256Hash(value); got != hash {
+		return nil, fmt.Errorf("repair source has wrong value for %s: hash %s", c, got)
+	}
+	if err := r.Dest.Put(key, value); err != nil {
+		return nil, fmt.Errorf("inserting %s: %w", c, err)
+	}
+	repairedNodes.WithLabelValues(nodeKind(key)).Inc()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.repaired = append(r.repaired, c)
+	if r.Log != nil {
+		if _, err := fmt.Fprintf(r.Log, "%s\t%s\t%s\t%s\n", time.Now().UTC().Format(time.RFC3339), nodeKind(key), hash, c); err != nil {
+			return nil, fmt.Errorf("writing repair log: %w", err)
+		}
+	}
+	log.Infof("repaired %s node %s", nodeKind(key), c)
+	return value, nil
+}
+
+// repairingDatabase passes lookups of missing keys to a Repairer
+type repairingDatabase struct {
+	ethdb.Database
+	repairer *Repairer
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// If the key is missing and cannot be repaired, the original error is returned along with the reason
+func (d *repairingDatabase) Get(key []byte) ([]byte, error) {
+	value, err := d.Database.Get(key)
+	if err == nil || !isNotFound(err) {
+		return value, err
+	}
+	value, rerr := d.repairer.repair(key)
+	if rerr != nil {
+		log.Warnf("could not repair missing node: %v", rerr)
+		return nil, err
+	}
+	return value, nil
+}
+
+// Counts the sources opened, to give each its own cache name
+var pgipfsSources atomic.Uint64
+
+// NewPGIPFSSource returns a database reading blocks from ipld.blocks, for use as a repair or copy source
+// It uses its own cache, so it can read from the same database as a Postgres validator, or alongside other sources
+func NewPGIPFSSource(db *sqlx.DB) ethdb.KeyValueReader {
+	return pgipfsethdb.NewDatabase(db, pgipfsethdb.CacheConfig{
+		Name:           fmt.Sprintf("source-%d", pgipfsSources.Add(1)),
+		Size:           16 * 1000 * 1000, // 16MB
+		ExpiryDuration: time.Hour,
+	})
+}
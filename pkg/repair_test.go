@@ -0,0 +1,100 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("Repair", func() {
+	var (
+		car         *carblockstore.ReadOnly
+		source      ethdb.Database
+		dest        ethdb.Database
+		repairLog   *bytes.Buffer
+		repairer    *validator.Repairer
+		storageCIDs []cid.Cid
+	)
+
+	BeforeEach(func() {
+		params := makeTmp("test_repair")
+		path := filepath.Join(tmp, "state.car")
+		writeCAR(path, false, trieStateNodes, missingNodeStorageNodes, mockCode)
+		car, err = validator.OpenCAR(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		source = rawdb.NewMemoryDatabase()
+		dest = rawdb.NewMemoryDatabase()
+		storageCIDs = nil
+		for _, node := range trieStorageNodes {
+			c, err := validatortest.RawdataToCid(cid.EthStorageTrie, node, multihash.KECCAK_256)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(source.Put(c.Bytes(), node)).To(Succeed())
+			storageCIDs = append(storageCIDs, c)
+		}
+		repairLog = new(bytes.Buffer)
+		repairer = &validator.Repairer{Source: source, Dest: dest, Log: repairLog}
+		params.Repair = repairer
+		v = validator.NewCARValidator(car, params)
+	})
+	AfterEach(func() {
+		car.Close()
+		os.RemoveAll(tmp)
+	})
+
+	It("Fills missing nodes from the source and continues", func() {
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+
+		repaired := repairer.Repaired()
+		Expect(repaired).ToNot(BeEmpty())
+		Expect(storageCIDs).To(ContainElements(repaired))
+		for _, c := range repaired {
+			value, err := dest.Get(c.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			expected, err := source.Get(c.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(expected))
+			Expect(repairLog.String()).To(ContainSubstring(c.String()))
+		}
+		Expect(strings.Count(repairLog.String(), "\n")).To(Equal(len(repaired)))
+	})
+
+	It("Does not accept values which do not match their key", func() {
+		for _, c := range storageCIDs {
+			Expect(source.Put(c.Bytes(), []byte("corrupt"))).To(Succeed())
+		}
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+		Expect(repairer.Repaired()).To(BeEmpty())
+		Expect(repairLog.Len()).To(BeZero())
+	})
+})
@@ -0,0 +1,261 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// Replica is a database with an ipld.blocks table, such as a Postgres read replica, which a ReplicaSet reads from
+type Replica struct {
+	Name string // used in logs and stats; should not contain credentials
+	DB   *sqlx.DB
+}
+
+// ReplicaConfig configures the health checks of a ReplicaSet
+type ReplicaConfig struct {
+	HealthInterval time.Duration // interval between health checks of each replica; defaults to 5s
+	// If set, a replica is only used once it has the state root node, i.e. it has replayed the block being validated
+	StateRoot common.Hash
+	Wait      time.Duration // how long NewReplicaSet waits for a replica to become usable
+	// If set, only blocks written at or before it are read, as by a BoundedDatabase
+	MaxBlockNumber *uint64
+}
+
+// ReplicaStats reports the state of a replica in a ReplicaSet
+type ReplicaStats struct {
+	Name     string
+	Healthy  bool
+	Lookups  uint64 // lookups served, including misses
+	Failures uint64 // lookups which failed with an error, taking the replica out of use
+}
+
+// ReplicaName returns a name for a replica from its connection string, without the credentials
+func ReplicaName(connString string) string {
+	if u, err := url.Parse(connString); err == nil && u.Scheme != "" {
+		u.User = nil
+		return u.String()
+	}
+	var fields []string
+	for _, field := range strings.Fields(connString) {
+		if !strings.HasPrefix(field, "password=") {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+type replica struct {
+	Replica
+	source ethdb.KeyValueReader
+
+	healthy  atomic.Bool
+	caughtUp bool // only accessed by health checks
+	lookups  atomic.Uint64
+	failures atomic.Uint64
+}
+
+// ReplicaSet spreads lookups across replicas round-robin. A replica whose lookup fails with an error is taken out of use
+// and the lookup retried on the next one; health checks put it back once it responds again. Misses are also retried on
+// the other replicas, in case one is behind.
+type ReplicaSet struct {
+	ethdb.Database
+	replicas []*replica
+	config   ReplicaConfig
+	next     atomic.Uint64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+var errNoHealthyReplicas = errors.New("no healthy replicas")
+
+// NewReplicaSet checks the health of the replicas, waiting up to config.Wait for one to become usable, and starts the
+// background health checks
+func NewReplicaSet(config ReplicaConfig, replicas ...Replica) (*ReplicaSet, error) {
+	if len(replicas) == 0 {
+		return nil, errors.New("no replicas")
+	}
+	if config.HealthInterval == 0 {
+		config.HealthInterval = 5 * time.Second
+	}
+	s := &ReplicaSet{config: config, stop: make(chan struct{})}
+	for _, r := range replicas {
+		var source ethdb.KeyValueReader = NewPGIPFSSource(r.DB)
+		if config.MaxBlockNumber != nil {
+			source = NewBoundedDatabase(r.DB, *config.MaxBlockNumber)
+		}
+		s.replicas = append(s.replicas, &replica{Replica: r, source: source})
+	}
+	deadline := time.Now().Add(config.Wait)
+	for s.check() == 0 {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w after waiting %s", errNoHealthyReplicas, config.Wait)
+		}
+		time.Sleep(config.HealthInterval)
+	}
+
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(config.HealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.check()
+			}
+		}
+	}()
+	return s, nil
+}
+
+// NewReplicaValidator returns a new trie validator reading from a replica set
+func NewReplicaValidator(set *ReplicaSet, par Params) *Validator {
+	return newValidator(set, set, "postgres", par)
+}
+
+// Checks the health of every replica, returning the number which are healthy
+func (s *ReplicaSet) check() int {
+	var healthy int
+	for _, r := range s.replicas {
+		err := s.checkReplica(r)
+		if was := r.healthy.Swap(err == nil); was && err != nil {
+			log.Warnf("replica %s is unhealthy: %v", r.Name, err)
+		} else if !was && err == nil {
+			log.Infof("replica %s is healthy", r.Name)
+		} else if err != nil {
+			log.Debugf("replica %s is still unhealthy: %v", r.Name, err)
+		}
+		if err == nil {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// Returns an error if the replica is unreachable or, when a state root is configured, has not replayed it yet
+func (s *ReplicaSet) checkReplica(r *replica) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.HealthInterval)
+	defer cancel()
+	if err := r.DB.PingContext(ctx); err != nil {
+		return err
+	}
+	if s.config.StateRoot == (common.Hash{}) || r.caughtUp {
+		return nil
+	}
+	c, err := keccak256ToCid(cid.EthStateTrie, s.config.StateRoot.Bytes())
+	if err != nil {
+		return err
+	}
+	var blockNumber uint64
+	if err := r.DB.GetContext(ctx, &blockNumber, getBlockNumberPgStr, c.String()); err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("state root %s not replayed yet", s.config.StateRoot)
+		}
+		return err
+	}
+	r.caughtUp = true
+	return nil
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (s *ReplicaSet) Get(key []byte) ([]byte, error) {
+	start := s.next.Add(1)
+	var missed, failed error
+	for i := range s.replicas {
+		r := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if !r.healthy.Load() {
+			continue
+		}
+		r.lookups.Add(1)
+		value, err := r.source.Get(key)
+		switch {
+		case err == nil:
+			return value, nil
+		case isNotFound(err):
+			missed = err
+		default:
+			r.failures.Add(1)
+			if r.healthy.Swap(false) {
+				log.Warnf("replica %s failed, failing over until it passes a health check: %v", r.Name, err)
+			}
+			failed = fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+	if missed != nil {
+		return nil, missed
+	}
+	if failed != nil {
+		return nil, fmt.Errorf("%w: %v", errNoHealthyReplicas, failed)
+	}
+	return nil, errNoHealthyReplicas
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (s *ReplicaSet) Has(key []byte) (bool, error) {
+	_, err := s.Get(key)
+	if err != nil && isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (s *ReplicaSet) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (s *ReplicaSet) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// Close stops the health checks; the replicas' databases are left open
+func (s *ReplicaSet) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.done.Wait()
+	return nil
+}
+
+// Stats returns the state of each replica
+func (s *ReplicaSet) Stats() []ReplicaStats {
+	stats := make([]ReplicaStats, len(s.replicas))
+	for i, r := range s.replicas {
+		stats[i] = ReplicaStats{Name: r.Name, Healthy: r.healthy.Load(), Lookups: r.lookups.Load(), Failures: r.failures.Load()}
+	}
+	return stats
+}
@@ -0,0 +1,249 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ipfs/go-cid"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var errDatabaseClosed = errors.New("database closed")
+
+// RPCConfig configures a JSON-RPC node source
+type RPCConfig struct {
+	URL           string
+	BatchSize     int           // maximum lookups sent in one batch request; 1 disables batching
+	BatchWait     time.Duration // how long to wait for lookups to fill a batch before sending it
+	MaxConcurrent int           // maximum requests in flight
+	Timeout       time.Duration // timeout of each request; 0 for none
+}
+
+// Defaults for unset RPCConfig fields
+const (
+	DefaultRPCBatchSize     = 100
+	DefaultRPCBatchWait     = 2 * time.Millisecond
+	DefaultRPCMaxConcurrent = 8
+)
+
+// RPCDatabase is an ethdb.Database reading nodes and code from a geth-compatible JSON-RPC endpoint, keyed by CID
+// Trie nodes and code are both read with debug_dbGet, by their hash-scheme keys: eth_getCode needs an account and block
+// rather than a code hash, so it cannot serve lookups by hash. Lookups made concurrently are coalesced into batch requests.
+type RPCDatabase struct {
+	ethdb.Database
+	client   *rpc.Client
+	config   RPCConfig
+	requests chan *rpcRequest
+	sem      chan struct{}
+	done     chan struct{}
+}
+
+type rpcRequest struct {
+	key    string // hex-encoded database key
+	result hexutil.Bytes
+	err    error
+	ready  chan struct{}
+}
+
+// DialRPC connects to a JSON-RPC endpoint and returns a database reading from it
+func DialRPC(ctx context.Context, config RPCConfig) (*RPCDatabase, error) {
+	client, err := rpc.DialContext(ctx, config.URL)
+	if err != nil {
+		return nil, err
+	}
+	return NewRPCDatabase(client, config), nil
+}
+
+// NewRPCDatabase returns a database reading from a JSON-RPC client
+func NewRPCDatabase(client *rpc.Client, config RPCConfig) *RPCDatabase {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultRPCBatchSize
+	}
+	if config.BatchWait <= 0 {
+		config.BatchWait = DefaultRPCBatchWait
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = DefaultRPCMaxConcurrent
+	}
+	d := &RPCDatabase{
+		client:   client,
+		config:   config,
+		requests: make(chan *rpcRequest),
+		sem:      make(chan struct{}, config.MaxConcurrent),
+		done:     make(chan struct{}),
+	}
+	go d.batchLoop()
+	return d
+}
+
+// NewRPCValidator returns a new trie validator ontop of a JSON-RPC endpoint
+func NewRPCValidator(db *RPCDatabase, par Params) *Validator {
+	return newValidator(db, db, "rpc", par)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// The value is checked against the hash in the key
+func (d *RPCDatabase) Get(key []byte) ([]byte, error) {
+	c, hash, err := keyToHash(key)
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	if c.Type() == cid.Raw {
+		// code is stored under a prefixed key, or the bare hash in older databases
+		value, err = d.dbGet(append(rawdb.CodePrefix, hash.Bytes()...))
+		if err != nil && isNotFound(err) {
+			value, err = d.dbGet(hash.Bytes())
+		}
+	} else {
+		value, err = d.dbGet(hash.Bytes())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if crypto.Keccak256Hash(value) != hash {
+		return nil, fmt.Errorf("rpc returned wrong value for %s", c)
+	}
+	return value, nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// The debug namespace has no existence check, so the whole value is fetched and verified as by Get.
+func (d *RPCDatabase) Has(key []byte) (bool, error) {
+	_, err := d.Get(key)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *RPCDatabase) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *RPCDatabase) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// Close satisfies the io.Closer interface
+func (d *RPCDatabase) Close() error {
+	close(d.done)
+	d.client.Close()
+	return nil
+}
+
+// Queues a debug_dbGet lookup and waits for its batch to complete
+func (d *RPCDatabase) dbGet(key []byte) ([]byte, error) {
+	req := &rpcRequest{key: hexutil.Encode(key), ready: make(chan struct{})}
+	select {
+	case d.requests <- req:
+	case <-d.done:
+		return nil, errDatabaseClosed
+	}
+	<-req.ready
+	return req.result, req.err
+}
+
+// Collects queued lookups into batches, sending each once it is full or has waited BatchWait
+func (d *RPCDatabase) batchLoop() {
+	for {
+		var batch []*rpcRequest
+		select {
+		case req := <-d.requests:
+			batch = append(batch, req)
+		case <-d.done:
+			return
+		}
+		timer := time.NewTimer(d.config.BatchWait)
+	collect:
+		for len(batch) < d.config.BatchSize {
+			select {
+			case req := <-d.requests:
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			case <-d.done:
+				break collect
+			}
+		}
+		timer.Stop()
+		d.sem <- struct{}{}
+		go func() {
+			defer func() { <-d.sem }()
+			d.send(batch)
+		}()
+	}
+}
+
+// Sends a batch of lookups, as a single call if there is only one
+func (d *RPCDatabase) send(batch []*rpcRequest) {
+	ctx := context.Background()
+	if d.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.config.Timeout)
+		defer cancel()
+	}
+	if len(batch) == 1 {
+		req := batch[0]
+		req.err = rpcLookupError(d.client.CallContext(ctx, &req.result, "debug_dbGet", req.key))
+		close(req.ready)
+		return
+	}
+	elems := make([]rpc.BatchElem, len(batch))
+	for i, req := range batch {
+		elems[i] = rpc.BatchElem{Method: "debug_dbGet", Args: []interface{}{req.key}, Result: &req.result}
+	}
+	err := d.client.BatchCallContext(ctx, elems)
+	for i, req := range batch {
+		req.err = rpcLookupError(elems[i].Error)
+		if err != nil {
+			req.err = err
+		}
+		close(req.ready)
+	}
+}
+
+// Messages of the errors geth's key-value stores return for absent keys, which debug_dbGet passes on as its error
+var rpcNotFoundMessages = map[string]bool{
+	leveldb.ErrNotFound.Error(): true,
+	pebble.ErrNotFound.Error():  true,
+	ErrNotFound.Error():         true, // memorydb
+}
+
+// Returns ErrNotFound, wrapped, for a debug_dbGet error reporting an absent key, and other errors as is
+func rpcLookupError(err error) error {
+	var rerr rpc.Error
+	if errors.As(err, &rerr) && rpcNotFoundMessages[rerr.Error()] {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+// Serves debug_dbGet from a map of hex-encoded keys, recording the largest batch and the most concurrent requests
+type rpcStandIn struct {
+	sync.Mutex
+	values map[string][]byte
+
+	maxBatch    int
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+	delay       time.Duration
+}
+
+type rpcMessage struct {
+	Version string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Params  []json.RawMessage `json:"params,omitempty"`
+	Result  interface{}       `json:"result,omitempty"`
+	Error   *rpcError         `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newRPCStandIn(stateNodes, storageNodes [][]byte, codeKey func([]byte) []byte, contractCode ...[]byte) *rpcStandIn {
+	s := &rpcStandIn{values: make(map[string][]byte)}
+	for _, node := range append(stateNodes, storageNodes...) {
+		s.values[hexutil.Encode(crypto.Keccak256(node))] = node
+	}
+	for _, code := range contractCode {
+		s.values[hexutil.Encode(codeKey(crypto.Keccak256(code)))] = code
+	}
+	return s
+}
+
+func (s *rpcStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		max := s.maxInFlight.Load()
+		if n <= max || s.maxInFlight.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+
+	// malformed requests fail the client's lookups, since assertions cannot be made on the handler's goroutine
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batch := bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+	var requests []rpcMessage
+	var err error
+	if batch {
+		err = json.Unmarshal(body, &requests)
+	} else {
+		requests = make([]rpcMessage, 1)
+		err = json.Unmarshal(body, &requests[0])
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Lock()
+	if len(requests) > s.maxBatch {
+		s.maxBatch = len(requests)
+	}
+	s.Unlock()
+
+	responses := make([]rpcMessage, len(requests))
+	for i, req := range requests {
+		responses[i] = rpcMessage{Version: "2.0", ID: req.ID}
+		var key string
+		if req.Method != "debug_dbGet" || len(req.Params) != 1 || json.Unmarshal(req.Params[0], &key) != nil {
+			responses[i].Error = &rpcError{Code: -32601, Message: "unsupported request"}
+			continue
+		}
+		if value, ok := s.values[key]; ok {
+			responses[i].Result = hexutil.Bytes(value)
+		} else {
+			responses[i].Error = &rpcError{Code: -32000, Message: "leveldb: not found"}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(responses)
+	} else {
+		json.NewEncoder(w).Encode(responses[0])
+	}
+}
+
+var _ = Describe("JSON-RPC validator", func() {
+	var (
+		server *httptest.Server
+		db     *validator.RPCDatabase
+		config validator.RPCConfig
+		params validator.Params
+	)
+
+	codeKey := func(hash []byte) []byte { return append(rawdb.CodePrefix, hash...) }
+	legacyCodeKey := func(hash []byte) []byte { return hash }
+
+	BeforeEach(func() {
+		params = makeTmp("test_rpc")
+		config = validator.RPCConfig{BatchSize: 16, BatchWait: time.Millisecond, MaxConcurrent: 2}
+	})
+	AfterEach(func() {
+		if db != nil {
+			db.Close()
+			db = nil
+		}
+		server.Close()
+		os.RemoveAll(tmp)
+	})
+
+	serve := func(s *rpcStandIn) {
+		server = httptest.NewServer(s)
+		config.URL = server.URL
+		db, err = validator.DialRPC(context.Background(), config)
+		Expect(err).ToNot(HaveOccurred())
+		v = validator.NewRPCValidator(db, params)
+	}
+
+	itValidatesStorageTries(func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+		serve(newRPCStandIn(stateNodes, storageNodes, codeKey, contractCode...))
+	})
+	It("Reads code stored under the legacy key", func() {
+		serve(newRPCStandIn(trieStateNodes, trieStorageNodes, legacyCodeKey, mockCode))
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+	})
+	It("Returns an error if the endpoint returns the wrong value", func() {
+		s := newRPCStandIn(trieStateNodes, trieStorageNodes, codeKey, mockCode)
+		for key := range s.values {
+			s.values[key] = []byte("corrupt")
+		}
+		serve(s)
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).To(HaveOccurred())
+	})
+	It("Batches concurrent lookups, within the concurrency limit", func() {
+		s := newRPCStandIn(trieStateNodes, trieStorageNodes, codeKey, mockCode)
+		s.delay = 5 * time.Millisecond
+		serve(s)
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+		Expect(s.maxBatch).To(BeNumerically(">", 1))
+		Expect(s.maxBatch).To(BeNumerically("<=", config.BatchSize))
+		Expect(s.maxInFlight.Load()).To(BeNumerically("<=", config.MaxConcurrent))
+	})
+})
@@ -0,0 +1,169 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	exportSnapshotPgStr = "SELECT pg_export_snapshot()"
+	getBlockPgStr       = "SELECT data FROM ipld.blocks WHERE key = $1 LIMIT 1"
+	hasBlockPgStr       = "SELECT exists(SELECT 1 FROM ipld.blocks WHERE key = $1)"
+)
+
+var snapshotTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+// SnapshotDatabase reads ipld.blocks in Postgres through REPEATABLE READ transactions which all share one snapshot,
+// exported with pg_export_snapshot, so every lookup sees the database as it was when the snapshot was taken, however
+// long the traversal runs and whatever is written meanwhile.
+// The exporting transaction is held open until Close, which holds back vacuum of ipld.blocks for as long.
+type SnapshotDatabase struct {
+	ethdb.Database
+	db       *sqlx.DB
+	exporter *sqlx.Tx
+	snapshot string
+	txs      chan *sqlx.Tx // reading transactions not in use
+
+	MaxBlockNumber *uint64 // if set, only blocks written at or before it are read, as by a BoundedDatabase
+}
+
+// NewSnapshotDatabase exports a snapshot and opens the given number of reading transactions on it
+// The pool of db must allow one connection more than the readers.
+func NewSnapshotDatabase(db *sqlx.DB, readers int) (*SnapshotDatabase, error) {
+	if readers < 1 {
+		readers = 1
+	}
+	if max := db.Stats().MaxOpenConnections; max > 0 && max <= readers {
+		return nil, fmt.Errorf("%d snapshot readers need %d connections, but the pool allows %d", readers, readers+1, max)
+	}
+	exporter, err := db.BeginTxx(context.Background(), snapshotTxOptions)
+	if err != nil {
+		return nil, err
+	}
+	d := &SnapshotDatabase{db: db, exporter: exporter, txs: make(chan *sqlx.Tx, readers)}
+	if err := exporter.Get(&d.snapshot, exportSnapshotPgStr); err != nil {
+		exporter.Rollback()
+		return nil, fmt.Errorf("exporting snapshot: %w", err)
+	}
+	for i := 0; i < readers; i++ {
+		tx, err := d.begin()
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.txs <- tx
+	}
+	return d, nil
+}
+
+// NewSnapshotValidator returns a new trie validator reading from a snapshot
+func NewSnapshotValidator(d *SnapshotDatabase, par Params) *Validator {
+	return newValidator(d, d, "postgres", par)
+}
+
+// Snapshot returns the ID of the exported snapshot, which other sessions can read with SET TRANSACTION SNAPSHOT
+func (d *SnapshotDatabase) Snapshot() string {
+	return d.snapshot
+}
+
+// Opens a reading transaction on the snapshot
+func (d *SnapshotDatabase) begin() (*sqlx.Tx, error) {
+	tx, err := d.db.BeginTxx(context.Background(), snapshotTxOptions)
+	if err != nil {
+		return nil, err
+	}
+	// the snapshot ID cannot be passed as a parameter
+	if _, err := tx.Exec("SET TRANSACTION SNAPSHOT " + pq.QuoteLiteral(d.snapshot)); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("importing snapshot %s: %w", d.snapshot, err)
+	}
+	return tx, nil
+}
+
+// Runs the query, or its bounded form if MaxBlockNumber is set, in a reading transaction
+// A transaction whose query fails is aborted by Postgres, so it is replaced with a new one on the same snapshot.
+func (d *SnapshotDatabase) get(dest interface{}, query, boundedQuery string, key []byte) error {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return err
+	}
+	tx := <-d.txs
+	defer func() { d.txs <- tx }()
+	if tx == nil {
+		if tx, err = d.begin(); err != nil {
+			return err
+		}
+	}
+	if d.MaxBlockNumber != nil {
+		err = tx.Get(dest, boundedQuery, c.String(), *d.MaxBlockNumber)
+	} else {
+		err = tx.Get(dest, query, c.String())
+	}
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		tx = nil // replaced by the next lookup
+	}
+	return err
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (d *SnapshotDatabase) Get(key []byte) ([]byte, error) {
+	var data []byte
+	if err := d.get(&data, getBlockPgStr, getBoundedBlockPgStr, key); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (d *SnapshotDatabase) Has(key []byte) (bool, error) {
+	var exists bool
+	err := d.get(&exists, hasBlockPgStr, hasBoundedBlockPgStr, key)
+	return exists, err
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *SnapshotDatabase) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *SnapshotDatabase) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// Close ends the reading transactions and then the exporting one; lookups must have finished
+func (d *SnapshotDatabase) Close() error {
+	for {
+		select {
+		case tx := <-d.txs:
+			if tx != nil {
+				tx.Rollback()
+			}
+		default:
+			return d.exporter.Rollback()
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"math/rand"
+	"os"
+
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
+)
+
+var _ = Describe("Snapshot reads", Label("postgres"), func() {
+	var (
+		fixture  *validatortest.Fixture
+		removed  validatortest.Node
+		snapshot *validator.SnapshotDatabase
+		params   validator.Params
+	)
+
+	// Publishes the fixture without one of its state nodes
+	BeforeEach(func() {
+		db, err = openTestDB()
+		Expect(err).ToNot(HaveOccurred())
+		params = makeTmp("test_snapshot")
+		fixture, err = validatortest.RandomFixture(rand.New(rand.NewSource(6)), 32, 8)
+		Expect(err).ToNot(HaveOccurred())
+		var ok bool
+		removed, ok = fixture.DeleteStateNode([]byte{0x4})
+		Expect(ok).To(BeTrue())
+		Expect(fixture.PublishDB(db)).To(Succeed())
+
+		snapshot, err = validator.NewSnapshotDatabase(db, 4)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.Snapshot()).ToNot(BeEmpty())
+		v = validator.NewSnapshotValidator(snapshot, params)
+	})
+	AfterEach(func() {
+		v.Close()
+		Expect(snapshot.Close()).To(Succeed())
+		Expect(ResetTestDB(db)).To(Succeed())
+		os.RemoveAll(tmp)
+		db.Close()
+	})
+
+	It("Does not see nodes written after the snapshot", func() {
+		tx, err := db.Beginx()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(validatortest.PublishRaw(tx, removed.Codec, multihash.KECCAK_256, removed.Data, fixture.BlockNumber)).To(Succeed())
+		Expect(tx.Commit()).To(Succeed())
+
+		err = v.ValidateTrie(fixture.StateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+
+		v.Close()
+		v = validator.NewPGIPFSValidator(db, params)
+		Expect(v.ValidateTrie(fixture.StateRoot)).To(Succeed())
+	})
+	It("Still sees nodes deleted after the snapshot", func() {
+		tx, err := db.Beginx()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(validatortest.PublishRaw(tx, removed.Codec, multihash.KECCAK_256, removed.Data, fixture.BlockNumber)).To(Succeed())
+		Expect(tx.Commit()).To(Succeed())
+		Expect(snapshot.Close()).To(Succeed())
+		snapshot, err = validator.NewSnapshotDatabase(db, 4)
+		Expect(err).ToNot(HaveOccurred())
+		v.Close()
+		v = validator.NewSnapshotValidator(snapshot, params)
+
+		Expect(ResetTestDB(db)).To(Succeed())
+		Expect(v.ValidateTrie(fixture.StateRoot)).To(Succeed())
+	})
+	It("Requires a connection for each reader and the exporting transaction", func() {
+		db.SetMaxOpenConns(4)
+		_, err := validator.NewSnapshotDatabase(db, 4)
+		Expect(err).To(MatchError(ContainSubstring("need 5 connections")))
+	})
+})
@@ -0,0 +1,156 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	createSQLiteBlocksStr = `CREATE TABLE IF NOT EXISTS ipld.blocks (
+		block_number BIGINT NOT NULL,
+		key TEXT NOT NULL,
+		data BLOB NOT NULL,
+		PRIMARY KEY (key, block_number)
+	)`
+	getSQLiteBlockStr    = "SELECT data FROM ipld.blocks WHERE key = $1 LIMIT 1"
+	hasSQLiteBlockStr    = "SELECT exists(SELECT 1 FROM ipld.blocks WHERE key = $1)"
+	putSQLiteBlockStr    = "INSERT INTO ipld.blocks (block_number, key, data) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	deleteSQLiteBlockStr = "DELETE FROM ipld.blocks WHERE key = $1"
+)
+
+// OpenSQLite opens a SQLite database file holding blocks in the ipld.blocks layout of Postgres
+// The file is attached as the "ipld" schema of every connection, so ipld.blocks can be queried as it is in Postgres.
+// If create is set, the file and the table are created if they do not exist; otherwise the file must exist.
+func OpenSQLite(path string, create bool) (*sqlx.DB, error) {
+	if !create {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	connector := &sqliteConnector{
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				if _, err := conn.Exec("ATTACH DATABASE $1 AS ipld", []driver.Value{path}); err != nil {
+					return err
+				}
+				// connections wait on each other's writes rather than failing with SQLITE_BUSY
+				_, err := conn.Exec("PRAGMA busy_timeout = 10000; PRAGMA ipld.journal_mode = WAL", nil)
+				return err
+			},
+		},
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "sqlite3")
+	if create {
+		if _, err := db.Exec(createSQLiteBlocksStr); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// sqliteConnector opens connections to an in-memory main database, to which the ConnectHook attaches the file
+type sqliteConnector struct {
+	driver *sqlite3.SQLiteDriver
+}
+
+// Connect satisfies the driver.Connector interface
+func (c *sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(":memory:")
+}
+
+// Driver satisfies the driver.Connector interface
+func (c *sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// SQLiteDatabase is an ethdb.KeyValueStore over the ipld.blocks table of a database opened with OpenSQLite, keyed by CID
+// Only the key-value reader and writer methods are implemented. Blocks are written with BlockNumber.
+type SQLiteDatabase struct {
+	ethdb.Database
+	db          *sqlx.DB
+	BlockNumber uint64
+}
+
+// NewSQLiteDatabase returns a database reading and writing the blocks of the SQLite database
+func NewSQLiteDatabase(db *sqlx.DB) *SQLiteDatabase {
+	return &SQLiteDatabase{db: db}
+}
+
+// NewSQLiteValidator returns a new trie validator ontop of a database opened with OpenSQLite
+func NewSQLiteValidator(db *sqlx.DB, par Params) *Validator {
+	database := NewSQLiteDatabase(db)
+	return newValidator(database, database, "sqlite", par)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (d *SQLiteDatabase) Get(key []byte) ([]byte, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := d.db.Get(&data, getSQLiteBlockStr, c.String()); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (d *SQLiteDatabase) Has(key []byte) (bool, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = d.db.Get(&exists, hasSQLiteBlockStr, c.String())
+	return exists, err
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *SQLiteDatabase) Put(key []byte, value []byte) error {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(putSQLiteBlockStr, d.BlockNumber, c.String(), value)
+	return err
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *SQLiteDatabase) Delete(key []byte) error {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(deleteSQLiteBlockStr, c.String())
+	return err
+}
+
+// Close satisfies the io.Closer interface
+func (d *SQLiteDatabase) Close() error {
+	return d.db.Close()
+}
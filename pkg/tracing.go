@@ -0,0 +1,110 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cerc-io/ipld-eth-statedb/trie_by_cid/trie"
+)
+
+// TracerName is the name of the tracer validation spans are recorded with
+const TracerName = "github.com/cerc-io/eth-ipfs-state-validator"
+
+// Spans are started through the global tracer provider, which is a no-op unless one has been set
+func tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// Starts the span covering a whole validation run
+func startValidationSpan(root common.Hash, traversal TraversalType, workers uint) (context.Context, trace.Span) {
+	return tracer().Start(context.Background(), "validate "+traversal, trace.WithAttributes(
+		attribute.String("validator.root", root.Hex()),
+		attribute.String("validator.traversal", traversal),
+		attribute.Int("validator.workers", int(workers)),
+	))
+}
+
+// Starts the span covering one worker's subtrie
+func startSubtrieSpan(ctx context.Context, it trie.NodeIterator) (context.Context, trace.Span) {
+	var attrs []attribute.KeyValue
+	if bounded, ok := it.(interface{ Bounds() ([]byte, []byte) }); ok {
+		start, end := bounded.Bounds()
+		attrs = append(attrs,
+			attribute.String("validator.start_path", fmt.Sprintf("%x", start)),
+			attribute.String("validator.end_path", fmt.Sprintf("%x", end)),
+		)
+	}
+	return tracer().Start(ctx, "subtrie", trace.WithAttributes(attrs...))
+}
+
+// Records a span for a storage trie once it has been traversed
+// Only tries above the size threshold are recorded, so the span is created after the fact
+func traceStorageTrie(ctx context.Context, started time.Time, addrHash, root common.Hash, nodes uint, err error) {
+	_, span := tracer().Start(ctx, "storage trie", trace.WithTimestamp(started), trace.WithAttributes(
+		attribute.String("validator.account", addrHash.Hex()),
+		attribute.String("validator.storage_root", root.Hex()),
+		attribute.Int("validator.nodes", int(nodes)),
+	))
+	endSpan(span, err)
+}
+
+// Ends a span, recording the error and any missing node it reports
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		var missing *trie.MissingNodeError
+		if errors.As(err, &missing) {
+			span.AddEvent("missing node", trace.WithAttributes(
+				attribute.String("validator.node_hash", missing.NodeHash.Hex()),
+				attribute.String("validator.owner", missing.Owner.Hex()),
+				attribute.String("validator.path", fmt.Sprintf("%x", missing.Path)),
+			))
+		} else if isNotFound(err) {
+			span.AddEvent("missing node", trace.WithAttributes(attribute.String("validator.error", err.Error())))
+		}
+		if !errors.Is(err, context.Canceled) {
+			span.RecordError(err)
+		}
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Adds an event to the current validation span for fetches slower than the configured threshold
+func (v *Validator) recordSlowFetch(key []byte, elapsed time.Duration) {
+	if v.params.TraceSlowFetch <= 0 || elapsed < v.params.TraceSlowFetch {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("validator.kind", nodeKind(key)),
+		attribute.Int64("validator.duration_us", elapsed.Microseconds()),
+	}
+	if c, err := cid.Cast(key); err == nil {
+		attrs = append(attrs, attribute.String("validator.cid", c.String()))
+	}
+	v.traceSpan.AddEvent("slow fetch", trace.WithAttributes(attrs...))
+}
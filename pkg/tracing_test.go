@@ -0,0 +1,105 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validator_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+var _ = Describe("Tracing", Label("postgres"), func() {
+	var (
+		recorder *tracetest.SpanRecorder
+		previous trace.TracerProvider
+	)
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		previous = otel.GetTracerProvider()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+		db, err = openTestDB()
+		Expect(err).ToNot(HaveOccurred())
+		params := makeTmp("test_tracing")
+		params.TraceStorageThreshold = 1
+		params.TraceSlowFetch = time.Nanosecond
+		v = validator.NewPGIPFSValidator(db, params)
+	})
+	AfterEach(func() {
+		otel.SetTracerProvider(previous)
+		err = ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		os.RemoveAll(tmp)
+		v.Close()
+		db.Close()
+	})
+
+	It("Records a span per validation, worker subtrie and storage trie", func() {
+		loadTrie(trieStateNodes, trieStorageNodes, mockCode)
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).ToNot(HaveOccurred())
+
+		spans := spansByName(recorder.Ended())
+		Expect(spans["validate full"]).To(HaveLen(1))
+		root := spans["validate full"][0]
+		Expect(spans["subtrie"]).To(HaveLen(4))
+		for _, span := range spans["subtrie"] {
+			Expect(span.Parent().SpanID()).To(Equal(root.SpanContext().SpanID()))
+		}
+		Expect(spans["storage trie"]).ToNot(BeEmpty())
+		Expect(eventNames(root)).To(ContainElement("slow fetch"))
+	})
+	It("Records missing nodes as span events", func() {
+		loadTrie(missingNodeStateNodes, trieStorageNodes, mockCode)
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).To(HaveOccurred())
+
+		spans := spansByName(recorder.Ended())
+		Expect(spans["validate full"][0].Status().Code).To(Equal(codes.Error))
+		var events []string
+		for _, span := range spans["subtrie"] {
+			events = append(events, eventNames(span)...)
+		}
+		Expect(events).To(ContainElement("missing node"))
+	})
+})
+
+func spansByName(spans []sdktrace.ReadOnlySpan) map[string][]sdktrace.ReadOnlySpan {
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+	return byName
+}
+
+func eventNames(span sdktrace.ReadOnlySpan) []string {
+	var names []string
+	for _, event := range span.Events() {
+		names = append(names, event.Name)
+	}
+	return names
+}
@@ -6,4 +6,5 @@ const (
 	fullTraversal    = "full"
 	stateTraversal   = "state"
 	storageTraversal = "storage"
+	copyTraversal    = "copy"
 )
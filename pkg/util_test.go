@@ -1,39 +1,113 @@
 package validator_test
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/jmoiron/sqlx"
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
 )
 
-// PublishRaw derives a cid from raw bytes and provided codec and multihash type, and writes it to the db tx
-func PublishRaw(tx *sqlx.Tx, codec, mh uint64, raw []byte, blockNumber uint64) error {
-	c, err := RawdataToCid(codec, raw, mh)
-	if err != nil {
-		return err
-	}
-	_, err = tx.Exec(
-		`INSERT INTO ipld.blocks (key, data, block_number) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
-		c.String(), raw, blockNumber)
+// ResetTestDB empties all used tables from the test DB, which is Postgres or SQLite
+func ResetTestDB(db *sqlx.DB) error {
+	_, err := db.Exec("DELETE FROM ipld.blocks")
 	return err
 }
 
-// RawdataToCid takes the desired codec, multihash type, and a slice of bytes
-// and returns the proper cid of the object.
-func RawdataToCid(codec uint64, rawdata []byte, multiHash uint64) (cid.Cid, error) {
-	c, err := cid.Prefix{
-		Codec:    codec,
-		Version:  1,
-		MhType:   multiHash,
-		MhLength: -1,
-	}.Sum(rawdata)
-	if err != nil {
-		return cid.Cid{}, err
+// openTestDB connects to the Postgres test DB, configured by the environment
+func openTestDB() (*sqlx.DB, error) {
+	if err := validator.LoadEnv(&config); err != nil {
+		return nil, err
 	}
-	return c, nil
+	return validator.Connect(config)
 }
 
-// ResetTestDB truncates all used tables from the test DB
-func ResetTestDB(db *sqlx.DB) error {
-	_, err := db.Exec("TRUNCATE ipld.blocks")
-	return err
+// gatherMetrics returns the counter and gauge values of a registry, keyed by name and labels in exposition format
+func gatherMetrics(reg *prometheus.Registry) map[string]float64 {
+	families, err := reg.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			key := family.GetName()
+			if len(labels) > 0 {
+				key += "{" + strings.Join(labels, ",") + "}"
+			}
+			switch {
+			case m.Counter != nil:
+				values[key] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				values[key] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+// makeTmp creates tmp for a spec, returning params which keep the recovery files in it
+func makeTmp(pattern string) validator.Params {
+	tmp, err = os.MkdirTemp("", pattern)
+	Expect(err).ToNot(HaveOccurred())
+	return validator.Params{Workers: 4, RecoveryFormat: filepath.Join(tmp, "recover_%s")}
+}
+
+// trieBlocks returns the state nodes, storage nodes and contract code as blocks under their CIDs
+func trieBlocks(stateNodes, storageNodes [][]byte, contractCode ...[]byte) []blocks.Block {
+	var bs []blocks.Block
+	for _, set := range []struct {
+		codec uint64
+		data  [][]byte
+	}{
+		{cid.EthStateTrie, stateNodes},
+		{cid.EthStorageTrie, storageNodes},
+		{cid.Raw, contractCode},
+	} {
+		for _, raw := range set.data {
+			c, err := validatortest.RawdataToCid(set.codec, raw, multihash.KECCAK_256)
+			Expect(err).ToNot(HaveOccurred())
+			block, err := blocks.NewBlockWithCid(raw, c)
+			Expect(err).ToNot(HaveOccurred())
+			bs = append(bs, block)
+		}
+	}
+	return bs
+}
+
+// putTrie writes the state nodes, storage nodes and contract code to a blockstore
+func putTrie(store interface {
+	Put(context.Context, blocks.Block) error
+}, stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+	for _, block := range trieBlocks(stateNodes, storageNodes, contractCode...) {
+		Expect(store.Put(context.Background(), block)).To(Succeed())
+	}
+}
+
+// itValidatesStorageTries adds the specs validating the complete state, and the state missing a storage node, through
+// the validator which load sets up over the given nodes
+func itValidatesStorageTries(load func(stateNodes, storageNodes [][]byte, contractCode ...[]byte)) {
+	It("Returns no error if the entire state can be validated", func() {
+		load(trieStateNodes, trieStorageNodes, mockCode)
+		Expect(v.ValidateTrie(stateRoot)).To(Succeed())
+	})
+	It("Returns an error if the storage trie is missing node(s)", func() {
+		load(trieStateNodes, missingNodeStorageNodes, mockCode)
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing trie node"))
+	})
 }
@@ -28,19 +28,21 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
 	"github.com/jmoiron/sqlx"
 	"github.com/mailgun/groupcache/v2"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 
 	iterutils "github.com/cerc-io/eth-iterator-utils"
 	"github.com/cerc-io/eth-iterator-utils/tracker"
-	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
 	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v0"
 	"github.com/cerc-io/ipld-eth-statedb/trie_by_cid/state"
 	"github.com/cerc-io/ipld-eth-statedb/trie_by_cid/trie"
@@ -53,12 +55,23 @@ type Validator struct {
 	stateDatabase state.Database
 	db            *pgipfsethdb.Database
 
-	params Params
+	params    Params
+	progress  *Progress  // progress of the current traversal
+	traceSpan trace.Span // span of the current traversal
 }
 
 type Params struct {
 	Workers        uint
-	RecoveryFormat string // %s substituted with traversal type
+	RecoveryFormat string        // %s substituted with traversal type
+	RecoveryStore  RecoveryStore // defaults to the local recovery files
+
+	ProgressInterval time.Duration // interval between progress log lines; 0 disables them
+	ProgressBar      bool          // draw a progress bar instead, if stderr is a terminal
+
+	TraceStorageThreshold uint          // storage tries with at least this many nodes get their own span; 0 disables them
+	TraceSlowFetch        time.Duration // node fetches taking at least this long are recorded as span events; 0 disables them
+
+	Repair *Repairer // fills in missing nodes from a secondary source, if set
 }
 
 var (
@@ -91,42 +104,63 @@ func NewPGIPFSValidator(db *sqlx.DB, par Params) *Validator {
 		ExpiryDuration: time.Hour * 8,    // 8 hours
 	})
 
-	normalizeParams(&par)
-	return &Validator{
-		kvs:           kvs,
-		trieDB:        trie.NewDatabase(NewKVSDatabaseWithAncient(kvs)),
-		stateDatabase: state.NewDatabase(database),
-		db:            database.(*pgipfsethdb.Database),
-		params:        par,
-	}
+	v := newValidator(kvs, database, "postgres", par)
+	v.db = database.(*pgipfsethdb.Database)
+	return v
 }
 
+// Progress returns the progress of the current or last traversal, or nil if none has started
+func (v *Validator) Progress() *Progress {
+	return v.progress
+}
+
+// GetCacheStats returns the groupcache stats of the Postgres backend, or empty stats for other backends
 func (v *Validator) GetCacheStats() groupcache.Stats {
+	if v.db == nil {
+		return groupcache.Stats{}
+	}
 	return v.db.GetCacheStats()
 }
 
 // NewIPFSValidator returns a new trie validator ontop of an IPFS blockservice
 func NewIPFSValidator(bs blockservice.BlockService, par Params) *Validator {
-	kvs := ipfsethdb.NewKeyValueStore(bs)
-	database := ipfsethdb.NewDatabase(bs)
-	normalizeParams(&par)
-	return &Validator{
-		kvs:           kvs,
-		trieDB:        trie.NewDatabase(NewKVSDatabaseWithAncient(kvs)),
-		stateDatabase: state.NewDatabase(database),
-		params:        par,
-	}
+	// blocks are looked up by the CIDs the trie resolves nodes with, rather than by keccak256 hash
+	database := NewBlockstoreDatabase(BlockServiceStore{bs})
+	return newValidator(database, database, "ipfs", par)
+}
+
+// NewKeyValueValidator returns a new trie validator ontop of a key-value store keyed by CID, with lookups instrumented
+// under the backend name
+func NewKeyValueValidator(kvs ethdb.KeyValueStore, backend string, par Params) *Validator {
+	return newValidator(kvs, rawdb.NewDatabase(kvs), backend, par)
 }
 
 // NewValidator returns a new trie validator
 // Validating the completeness of a modified merkle patricia tries requires traversing the entire trie and verifying that
 // every node is present, this is an expensive operation
 func NewValidator(kvs ethdb.KeyValueStore, database ethdb.Database) *Validator {
-	return &Validator{
-		kvs:           kvs,
-		trieDB:        trie.NewDatabase(NewKVSDatabaseWithAncient(kvs)),
-		stateDatabase: state.NewDatabase(database),
+	return newValidator(kvs, database, "kvs", Params{})
+}
+
+// Returns a validator reading from the database, with lookups instrumented under the backend name
+func newValidator(kvs ethdb.KeyValueStore, database ethdb.Database, backend string, par Params) *Validator {
+	normalizeParams(&par)
+	v := &Validator{
+		kvs:       kvs,
+		trieDB:    trie.NewDatabase(NewKVSDatabaseWithAncient(kvs)),
+		params:    par,
+		traceSpan: trace.SpanFromContext(context.Background()),
+	}
+	database = &instrumentedDatabase{
+		Database:  database,
+		backend:   backend,
+		slowFetch: v.recordSlowFetch,
+	}
+	if par.Repair != nil {
+		database = &repairingDatabase{Database: database, repairer: par.Repair}
 	}
+	v.stateDatabase = state.NewDatabase(database)
+	return v
 }
 
 // Ensure params are valid
@@ -137,6 +171,9 @@ func normalizeParams(p *Params) {
 	if len(p.RecoveryFormat) == 0 {
 		p.RecoveryFormat = DefaultRecoveryFormat
 	}
+	if p.RecoveryStore == nil {
+		p.RecoveryStore = FileRecoveryStore{Format: p.RecoveryFormat}
+	}
 }
 
 // ValidateTrie returns an error if the state and storage tries for the provided state root cannot be confirmed as complete
@@ -146,8 +183,8 @@ func (v *Validator) ValidateTrie(stateRoot common.Hash) error {
 	if err != nil {
 		return err
 	}
-	iterate := func(ctx context.Context, it trie.NodeIterator) error { return v.iterate(ctx, it, true) }
-	return iterateTracked(t, fmt.Sprintf(v.params.RecoveryFormat, fullTraversal), v.params.Workers, iterate)
+	iterate := func(ctx context.Context, it trie.NodeIterator) error { return v.iterate(ctx, it, true, nil) }
+	return v.iterateRecoverable(t, stateRoot, fullTraversal, iterate)
 }
 
 // ValidateStateTrie returns an error if the state trie for the provided state root cannot be confirmed as complete
@@ -158,8 +195,8 @@ func (v *Validator) ValidateStateTrie(stateRoot common.Hash) error {
 	if err != nil {
 		return err
 	}
-	iterate := func(ctx context.Context, it trie.NodeIterator) error { return v.iterate(ctx, it, false) }
-	return iterateTracked(t, fmt.Sprintf(v.params.RecoveryFormat, stateTraversal), v.params.Workers, iterate)
+	iterate := func(ctx context.Context, it trie.NodeIterator) error { return v.iterate(ctx, it, false, nil) }
+	return v.iterateRecoverable(t, stateRoot, stateTraversal, iterate)
 }
 
 // ValidateStorageTrie returns an error if the storage trie for the provided storage root and contract address cannot be confirmed as complete
@@ -170,8 +207,8 @@ func (v *Validator) ValidateStorageTrie(stateRoot common.Hash, address common.Ad
 	if err != nil {
 		return err
 	}
-	iterate := func(ctx context.Context, it trie.NodeIterator) error { return v.iterate(ctx, it, false) }
-	return iterateTracked(t, fmt.Sprintf(v.params.RecoveryFormat, storageTraversal), v.params.Workers, iterate)
+	iterate := func(ctx context.Context, it trie.NodeIterator) error { return v.iterate(ctx, it, false, nil) }
+	return v.iterateRecoverable(t, storageRoot, storageTraversal, iterate)
 }
 
 // Close implements io.Closer
@@ -182,9 +219,15 @@ func (v *Validator) Close() error {
 	return nil
 }
 
+// nodeVisitor is called with each node and code blob reached by a traversal, keyed by the codec of its CID
+// statePath is the path of the state trie node, or of the account a storage node or code blob belongs to, and
+// storagePath the path of a storage node in its trie
+type nodeVisitor func(codec uint64, hash common.Hash, blob []byte, statePath, storagePath []byte) error
+
 // Traverses one iterator fully
 // If storage = true, also traverse storage tries for each leaf.
-func (v *Validator) iterate(ctx context.Context, it trie.NodeIterator, storage bool) error {
+// If visit is set, it is called with every node and code blob; the iterator must be over the state trie.
+func (v *Validator) iterate(ctx context.Context, it trie.NodeIterator, storage bool, visit nodeVisitor) error {
 	// Iterate through entire state trie. it.Next() will return false when we have
 	// either completed iteration of the entire trie or run into an error (e.g. a
 	// missing node). If we are able to iterate through the entire trie without error
@@ -195,6 +238,11 @@ func (v *Validator) iterate(ctx context.Context, it trie.NodeIterator, storage b
 			return ctx.Err()
 		default:
 		}
+		if visit != nil {
+			if err := visitNode(it, cid.EthStateTrie, it.Path(), nil, visit); err != nil {
+				return err
+			}
+		}
 
 		// This block adapted from geth - core/state/iterator.go
 		// If storage is not requested, or the state trie node is an internal entry, skip
@@ -202,6 +250,7 @@ func (v *Validator) iterate(ctx context.Context, it trie.NodeIterator, storage b
 			continue
 		}
 		// Otherwise we've reached an account node, initiate data iteration
+		v.progress.accounts.Add(1)
 		var account types.StateAccount
 		if err := rlp.Decode(bytes.NewReader(it.LeafBlob()), &account); err != nil {
 			return err
@@ -210,28 +259,100 @@ func (v *Validator) iterate(ctx context.Context, it trie.NodeIterator, storage b
 		if err != nil {
 			return err
 		}
-		dataIt := dataTrie.NodeIterator(nil)
+		dataIt := v.progress.trackStorage(dataTrie.NodeIterator(nil))
 		if !bytes.Equal(account.CodeHash, emptyCodeHash) {
-			_, err := v.stateDatabase.ContractCode(common.BytesToHash(account.CodeHash))
+			code, err := v.stateDatabase.ContractCode(common.BytesToHash(account.CodeHash))
 			if err != nil {
 				return fmt.Errorf("code hash %x: %w (path %x)", account.CodeHash, err, iterutils.HexToKeyBytes(it.Path()))
 			}
+			if visit != nil {
+				if err := visit(cid.Raw, common.BytesToHash(account.CodeHash), code, it.Path(), nil); err != nil {
+					return err
+				}
+			}
 		}
+		started := time.Now()
+		var nodes uint
 		for dataIt.Next(true) {
+			nodes++
+			if visit != nil {
+				if err := visitNode(dataIt, cid.EthStorageTrie, it.Path(), dataIt.Path(), visit); err != nil {
+					return err
+				}
+			}
+		}
+		if threshold := v.params.TraceStorageThreshold; threshold > 0 && nodes >= threshold {
+			traceStorageTrie(ctx, started, common.BytesToHash(it.LeafKey()), account.Root, nodes, dataIt.Error())
 		}
 		if dataIt.Error() != nil {
 			return fmt.Errorf("data iterator error (path %x): %w", iterutils.HexToKeyBytes(dataIt.Path()), dataIt.Error())
 		}
+		if account.Root != types.EmptyRootHash {
+			v.progress.storageTries.Add(1)
+		}
 	}
 	return it.Error()
 }
 
+// Passes the iterator's current node to the visitor, unless it is embedded in its parent
+func visitNode(it trie.NodeIterator, codec uint64, statePath, storagePath []byte, visit nodeVisitor) error {
+	if it.Hash() == (common.Hash{}) {
+		return nil
+	}
+	blob := it.NodeBlob()
+	if blob == nil {
+		if err := it.Error(); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to resolve node %x (path %x)", it.Hash(), it.Path())
+	}
+	return visit(codec, it.Hash(), blob, statePath, storagePath)
+}
+
+// Traverses the trie with tracked iterators, restoring from and persisting to the configured recovery store.
+// The recovery state is locked for the duration, so concurrent runs cannot clobber each other's progress.
+func (v *Validator) iterateRecoverable(
+	tree state.Trie,
+	root common.Hash,
+	traversal TraversalType,
+	fn func(context.Context, trie.NodeIterator) error,
+) error {
+	recoveryFile := fmt.Sprintf(v.params.RecoveryFormat, traversal)
+	unlock, err := v.params.RecoveryStore.Lock(root, traversal, recoveryFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			log.Errorf("failed to release recovery lock: %v", err)
+		}
+	}()
+
+	if err := v.params.RecoveryStore.Fetch(root, traversal, recoveryFile); err != nil {
+		return fmt.Errorf("failed to fetch recovery state: %w", err)
+	}
+	ctx, span := startValidationSpan(root, traversal, v.params.Workers)
+	v.traceSpan = span
+	defer func() { endSpan(span, err) }()
+
+	v.progress = newProgress()
+	stopReporting := v.progress.start(v.params.ProgressInterval, v.params.ProgressBar)
+	err = iterateTracked(ctx, tree, recoveryFile, v.params.Workers, v.progress, fn)
+	stopReporting()
+	if storeErr := v.params.RecoveryStore.Store(root, traversal, recoveryFile); storeErr != nil {
+		log.Errorf("failed to store recovery state: %v", storeErr)
+	}
+	return err
+}
+
 // Traverses each iterator in a separate goroutine.
 // Dumps to a recovery file on failure or interrupt.
 func iterateTracked(
+	ctx context.Context,
 	tree state.Trie,
 	recoveryFile string,
 	iterCount uint,
+	progress *Progress,
 	fn func(context.Context, trie.NodeIterator) error,
 ) error {
 	tracker := tracker.New(recoveryFile, iterCount)
@@ -260,7 +381,7 @@ func iterateTracked(
 		log.Debugf("restored %d iterators from: %s", len(iters), recoveryFile)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	g, ctx := errgroup.WithContext(ctx)
 
 	sigChan := make(chan os.Signal, 1)
@@ -274,8 +395,14 @@ func iterateTracked(
 	defer halt()
 	for _, it := range iters {
 		func(it trie.NodeIterator) {
+			tracked := progress.trackWorker(it)
 			g.Go(func() error {
-				return fn(ctx, it)
+				activeWorkers.Inc()
+				defer activeWorkers.Dec()
+				ctx, span := startSubtrieSpan(ctx, it)
+				err := fn(ctx, tracked)
+				endSpan(span, err)
+				return err
 			})
 
 		}(it)
@@ -25,13 +25,18 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid/_rsrch/cidiface"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	"github.com/jmoiron/sqlx"
 	"github.com/multiformats/go-multihash"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+	"github.com/cerc-io/eth-ipfs-state-validator/v5/pkg/validatortest"
 )
 
 var (
@@ -209,115 +214,167 @@ var (
 	}
 )
 
-var _ = Describe("PG-IPFS Validator", func() {
+// Databases with the ipld.blocks layout which the fixtures are loaded into and validated from
+var blocksDatabases = []struct {
+	name         string
+	labels       Labels
+	open         func() (*sqlx.DB, error)
+	newValidator func(*sqlx.DB, validator.Params) *validator.Validator
+}{
+	{"PG-IPFS", Labels{"postgres"}, openTestDB, validator.NewPGIPFSValidator},
+	{"SQLite", nil, func() (*sqlx.DB, error) {
+		return validator.OpenSQLite(filepath.Join(tmp, "blocks.sqlite"), true)
+	}, validator.NewSQLiteValidator},
+}
+
+var _ = Describe("ipld.blocks validator", func() {
+	for _, backend := range blocksDatabases {
+		backend := backend
+		Describe(backend.name+" Validator", backend.labels, func() {
+			BeforeEach(func() {
+				tmp, err = os.MkdirTemp("", "test_validator")
+				Expect(err).ToNot(HaveOccurred())
+				db, err = backend.open()
+				Expect(err).ToNot(HaveOccurred())
+				params := validator.Params{Workers: 4, RecoveryFormat: filepath.Join(tmp, "recover_%s")}
+				v = backend.newValidator(db, params)
+			})
+			AfterEach(func() {
+				os.RemoveAll(tmp)
+				v.Close()
+				db.Close()
+			})
+			Describe("ValidateTrie", func() {
+				AfterEach(func() {
+					err = ResetTestDB(db)
+					Expect(err).ToNot(HaveOccurred())
+				})
+				It("Returns an error if the state root node is missing", func() {
+					// we write code to ethdb, there should probably be an EthCode IPLD codec
+					// but there isn't, and we don't need one here since blockstore keys are mh-derived
+					loadTrie(missingRootStateNodes, trieStorageNodes, mockCode)
+					err = v.ValidateTrie(stateRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("missing trie node"))
+				})
+				It("Returns an error if the storage root node is missing", func() {
+					loadTrie(trieStateNodes, missingRootStorageNodes, mockCode)
+					err = v.ValidateTrie(stateRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("missing trie node"))
+				})
+				It("Returns an error if the state trie is missing node(s)", func() {
+					loadTrie(missingNodeStateNodes, trieStorageNodes, mockCode)
+					err = v.ValidateTrie(stateRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("missing trie node"))
+					Expect(err.Error()).To(ContainSubstring("%x", missingStateNodePath))
+				})
+				It("Returns an error if the storage trie is missing node(s)", func() {
+					loadTrie(trieStateNodes, missingNodeStorageNodes, mockCode)
+					err = v.ValidateTrie(stateRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("missing trie node"))
+					Expect(err.Error()).To(ContainSubstring("%x", missingStorageNodePath))
+				})
+				It("Returns an error if contract code is missing", func() {
+					loadTrie(trieStateNodes, trieStorageNodes)
+					err = v.ValidateTrie(stateRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("%x", codeHash))
+					Expect(err.Error()).To(ContainSubstring("%x", codePath))
+				})
+				It("Returns no error if the entire state (state trie and storage tries) can be validated", func() {
+					loadTrie(trieStateNodes, trieStorageNodes, mockCode)
+					err = v.ValidateTrie(stateRoot)
+					Expect(err).ToNot(HaveOccurred())
+				})
+				It("Reports the progress of the traversal", func() {
+					loadTrie(trieStateNodes, trieStorageNodes, mockCode)
+					err = v.ValidateTrie(stateRoot)
+					Expect(err).ToNot(HaveOccurred())
+
+					report := v.Progress().Report()
+					Expect(report.Coverage).To(BeNumerically("~", 1))
+					Expect(report.Workers).To(HaveLen(4))
+					Expect(report.Accounts).To(Equal(uint64(len(trieStateNodes) - 1)))
+					Expect(report.StorageTries).To(Equal(uint64(1)))
+					Expect(report.Nodes).To(BeNumerically(">=", len(trieStateNodes)+len(trieStorageNodes)))
+				})
+			})
+
+			Describe("ValidateStateTrie", func() {
+				AfterEach(func() {
+					err = ResetTestDB(db)
+					Expect(err).ToNot(HaveOccurred())
+				})
+				It("Returns an error the state root node is missing", func() {
+					loadTrie(missingRootStateNodes, nil)
+					err = v.ValidateStateTrie(stateRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("missing trie node"))
+				})
+				It("Returns an error if the entire state trie cannot be validated", func() {
+					loadTrie(missingNodeStateNodes, nil)
+					err = v.ValidateStateTrie(stateRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("missing trie node"))
+				})
+				It("Returns no error if the entire state trie can be validated", func() {
+					loadTrie(trieStateNodes, nil)
+					err = v.ValidateStateTrie(stateRoot)
+					Expect(err).ToNot(HaveOccurred())
+				})
+			})
+
+			Describe("ValidateStorageTrie", func() {
+				AfterEach(func() {
+					err = ResetTestDB(db)
+					Expect(err).ToNot(HaveOccurred())
+				})
+				It("Returns an error the storage root node is missing", func() {
+					loadTrie(nil, missingRootStorageNodes)
+					err = v.ValidateStorageTrie(stateRoot, contractAddr, storageRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("missing trie node"))
+				})
+				It("Returns an error if the entire storage trie cannot be validated", func() {
+					loadTrie(nil, missingNodeStorageNodes)
+					err = v.ValidateStorageTrie(stateRoot, contractAddr, storageRoot)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("missing trie node"))
+				})
+				It("Returns no error if the entire storage trie can be validated", func() {
+					loadTrie(nil, trieStorageNodes)
+					err = v.ValidateStorageTrie(stateRoot, contractAddr, storageRoot)
+					Expect(err).ToNot(HaveOccurred())
+				})
+			})
+		})
+	}
+})
+
+var _ = Describe("IPFS validator", func() {
+	var bs blockstore.Blockstore
+
 	BeforeEach(func() {
-		err = validator.LoadEnv(&config)
-		Expect(err).ToNot(HaveOccurred())
-		db, err = sqlx.Connect("postgres", config.ConnString())
-		Expect(err).ToNot(HaveOccurred())
-		tmp, err = os.MkdirTemp("", "test_validator")
-		Expect(err).ToNot(HaveOccurred())
-		params := validator.Params{Workers: 4, RecoveryFormat: filepath.Join(tmp, "recover_%s")}
-		v = validator.NewPGIPFSValidator(db, params)
+		params := makeTmp("test_ipfs")
+		bs = blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		v = validator.NewIPFSValidator(blockservice.New(bs, nil), params)
 	})
 	AfterEach(func() {
-		os.RemoveAll(tmp)
 		v.Close()
-		db.Close()
-	})
-	Describe("ValidateTrie", func() {
-		AfterEach(func() {
-			err = ResetTestDB(db)
-			Expect(err).ToNot(HaveOccurred())
-		})
-		It("Returns an error if the state root node is missing", func() {
-			// we write code to ethdb, there should probably be an EthCode IPLD codec
-			// but there isn't, and we don't need one here since blockstore keys are mh-derived
-			loadTrie(missingRootStateNodes, trieStorageNodes, mockCode)
-			err = v.ValidateTrie(stateRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("missing trie node"))
-		})
-		It("Returns an error if the storage root node is missing", func() {
-			loadTrie(trieStateNodes, missingRootStorageNodes, mockCode)
-			err = v.ValidateTrie(stateRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("missing trie node"))
-		})
-		It("Returns an error if the state trie is missing node(s)", func() {
-			loadTrie(missingNodeStateNodes, trieStorageNodes, mockCode)
-			err = v.ValidateTrie(stateRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("missing trie node"))
-			Expect(err.Error()).To(ContainSubstring("%x", missingStateNodePath))
-		})
-		It("Returns an error if the storage trie is missing node(s)", func() {
-			loadTrie(trieStateNodes, missingNodeStorageNodes, mockCode)
-			err = v.ValidateTrie(stateRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("missing trie node"))
-			Expect(err.Error()).To(ContainSubstring("%x", missingStorageNodePath))
-		})
-		It("Returns an error if contract code is missing", func() {
-			loadTrie(trieStateNodes, trieStorageNodes)
-			err = v.ValidateTrie(stateRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("%x", codeHash))
-			Expect(err.Error()).To(ContainSubstring("%x", codePath))
-		})
-		It("Returns no error if the entire state (state trie and storage tries) can be validated", func() {
-			loadTrie(trieStateNodes, trieStorageNodes, mockCode)
-			err = v.ValidateTrie(stateRoot)
-			Expect(err).ToNot(HaveOccurred())
-		})
+		os.RemoveAll(tmp)
 	})
 
-	Describe("ValidateStateTrie", func() {
-		AfterEach(func() {
-			err = ResetTestDB(db)
-			Expect(err).ToNot(HaveOccurred())
-		})
-		It("Returns an error the state root node is missing", func() {
-			loadTrie(missingRootStateNodes, nil)
-			err = v.ValidateStateTrie(stateRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("missing trie node"))
-		})
-		It("Returns an error if the entire state trie cannot be validated", func() {
-			loadTrie(missingNodeStateNodes, nil)
-			err = v.ValidateStateTrie(stateRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("missing trie node"))
-		})
-		It("Returns no error if the entire state trie can be validated", func() {
-			loadTrie(trieStateNodes, nil)
-			err = v.ValidateStateTrie(stateRoot)
-			Expect(err).ToNot(HaveOccurred())
-		})
+	itValidatesStorageTries(func(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
+		putTrie(bs, stateNodes, storageNodes, contractCode...)
 	})
-
-	Describe("ValidateStorageTrie", func() {
-		AfterEach(func() {
-			err = ResetTestDB(db)
-			Expect(err).ToNot(HaveOccurred())
-		})
-		It("Returns an error the storage root node is missing", func() {
-			loadTrie(nil, missingRootStorageNodes)
-			err = v.ValidateStorageTrie(stateRoot, contractAddr, storageRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("missing trie node"))
-		})
-		It("Returns an error if the entire storage trie cannot be validated", func() {
-			loadTrie(nil, missingNodeStorageNodes)
-			err = v.ValidateStorageTrie(stateRoot, contractAddr, storageRoot)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("missing trie node"))
-		})
-		It("Returns no error if the entire storage trie can be validated", func() {
-			loadTrie(nil, trieStorageNodes)
-			err = v.ValidateStorageTrie(stateRoot, contractAddr, storageRoot)
-			Expect(err).ToNot(HaveOccurred())
-		})
+	It("Returns an error if contract code is missing", func() {
+		putTrie(bs, trieStateNodes, trieStorageNodes)
+		err = v.ValidateTrie(stateRoot)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("%x", codeHash))
 	})
 })
 
@@ -325,15 +382,15 @@ func loadTrie(stateNodes, storageNodes [][]byte, contractCode ...[]byte) {
 	tx, err := db.Beginx()
 	Expect(err).ToNot(HaveOccurred())
 	for _, node := range stateNodes {
-		err := PublishRaw(tx, cid.EthStateTrie, multihash.KECCAK_256, node, blockNumber)
+		err := validatortest.PublishRaw(tx, cid.EthStateTrie, multihash.KECCAK_256, node, blockNumber)
 		Expect(err).ToNot(HaveOccurred())
 	}
 	for _, node := range storageNodes {
-		err := PublishRaw(tx, cid.EthStorageTrie, multihash.KECCAK_256, node, blockNumber)
+		err := validatortest.PublishRaw(tx, cid.EthStorageTrie, multihash.KECCAK_256, node, blockNumber)
 		Expect(err).ToNot(HaveOccurred())
 	}
 	for _, code := range contractCode {
-		err := PublishRaw(tx, cid.Raw, multihash.KECCAK_256, code, blockNumber)
+		err := validatortest.PublishRaw(tx, cid.Raw, multihash.KECCAK_256, code, blockNumber)
 		Expect(err).ToNot(HaveOccurred())
 	}
 	err = tx.Commit()
@@ -0,0 +1,217 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validatortest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+// ErrTransient is returned for reads failed by an injected transient error
+var ErrTransient = errors.New("injected transient error")
+
+// Returned for keys dropped from a store; the validator counts it as a missing node
+var errDropped = fmt.Errorf("%w (dropped by fault injection)", validator.ErrNotFound)
+
+// Faults configures the faults injected into reads by a FaultyStore or FaultyBlockstore
+// Keys are the keys of the wrapped store; for a blockstore they are CID bytes. The random faults are drawn from a
+// source seeded with Seed, so a sequence of reads fails the same way each time.
+type Faults struct {
+	Drop      func(key []byte) bool // keys reported as missing
+	Corrupt   func(key []byte) bool // keys whose values are returned with their first byte flipped
+	Fail      func(key []byte) bool // keys FailFirst applies to; all keys if nil
+	FailFirst int                   // number of reads of each key which fail with ErrTransient before it is served
+
+	DropRate    float64 // probability that a read of any other key reports it missing
+	CorruptRate float64 // probability that a read of any other key returns a corrupted value
+	ErrorRate   float64 // probability that a read fails with ErrTransient
+
+	Latency time.Duration // delay added to every read
+	Jitter  time.Duration // upper bound of a random delay added to every read
+	Seed    int64
+}
+
+// FaultStats counts the reads of a faulty store and the faults injected into them
+type FaultStats struct {
+	Reads     uint64
+	Dropped   uint64
+	Corrupted uint64
+	Errors    uint64
+}
+
+// Keys returns a Faults key filter matching the keys
+func Keys(keys ...[]byte) func([]byte) bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[string(key)] = true
+	}
+	return func(key []byte) bool { return set[string(key)] }
+}
+
+// CIDs returns a Faults key filter matching the CIDs, for stores keyed by CID
+func CIDs(cids ...cid.Cid) func([]byte) bool {
+	keys := make([][]byte, len(cids))
+	for i, c := range cids {
+		keys[i] = c.Bytes()
+	}
+	return Keys(keys...)
+}
+
+// Decides the fault injected into each read
+type injector struct {
+	faults Faults
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	attempts map[string]int
+	stats    FaultStats
+}
+
+func newInjector(faults Faults) *injector {
+	return &injector{faults: faults, rng: rand.New(rand.NewSource(faults.Seed)), attempts: make(map[string]int)}
+}
+
+// Returns the fault to inject into a read of the key, after sleeping for the configured latency
+func (in *injector) inject(key []byte) (drop, corrupt bool, err error) {
+	f := in.faults
+	in.mu.Lock()
+	delay := f.Latency
+	if f.Jitter > 0 {
+		delay += time.Duration(in.rng.Int63n(int64(f.Jitter)))
+	}
+	in.stats.Reads++
+	switch {
+	case (f.Fail == nil || f.Fail(key)) && in.attempts[string(key)] < f.FailFirst:
+		in.attempts[string(key)]++
+		err = ErrTransient
+	case f.ErrorRate > 0 && in.rng.Float64() < f.ErrorRate:
+		err = ErrTransient
+	case f.Drop != nil && f.Drop(key), f.DropRate > 0 && in.rng.Float64() < f.DropRate:
+		drop = true
+		in.stats.Dropped++
+	case f.Corrupt != nil && f.Corrupt(key), f.CorruptRate > 0 && in.rng.Float64() < f.CorruptRate:
+		corrupt = true
+		in.stats.Corrupted++
+	}
+	if err != nil {
+		in.stats.Errors++
+	}
+	in.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return drop, corrupt, err
+}
+
+// Stats returns the counts of reads and injected faults so far
+func (in *injector) Stats() FaultStats {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.stats
+}
+
+func corrupted(value []byte) []byte {
+	value = append([]byte{}, value...)
+	if len(value) > 0 {
+		value[0] ^= 0xff
+	}
+	return value
+}
+
+// FaultyStore wraps an ethdb.KeyValueStore, injecting faults into its reads
+type FaultyStore struct {
+	ethdb.KeyValueStore
+	*injector
+}
+
+// NewFaultyStore returns a store reading from kvs with the faults injected
+func NewFaultyStore(kvs ethdb.KeyValueStore, faults Faults) *FaultyStore {
+	return &FaultyStore{KeyValueStore: kvs, injector: newInjector(faults)}
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (s *FaultyStore) Get(key []byte) ([]byte, error) {
+	drop, corrupt, err := s.inject(key)
+	if err != nil {
+		return nil, err
+	}
+	if drop {
+		return nil, errDropped
+	}
+	value, err := s.KeyValueStore.Get(key)
+	if err != nil || !corrupt {
+		return value, err
+	}
+	return corrupted(value), nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+func (s *FaultyStore) Has(key []byte) (bool, error) {
+	drop, _, err := s.inject(key)
+	if err != nil || drop {
+		return false, err
+	}
+	return s.KeyValueStore.Has(key)
+}
+
+// FaultyBlockstore wraps a blockstore, such as a validator.BlockServiceStore, injecting faults into its reads
+type FaultyBlockstore struct {
+	bs validator.BlockGetter
+	*injector
+}
+
+// NewFaultyBlockstore returns a blockstore reading from bs with the faults injected
+func NewFaultyBlockstore(bs validator.BlockGetter, faults Faults) *FaultyBlockstore {
+	return &FaultyBlockstore{bs: bs, injector: newInjector(faults)}
+}
+
+// Get satisfies the validator.BlockGetter interface
+func (b *FaultyBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	drop, corrupt, err := b.inject(c.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if drop {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	block, err := b.bs.Get(ctx, c)
+	if err != nil || !corrupt {
+		return block, err
+	}
+	return blocks.NewBlockWithCid(corrupted(block.RawData()), c)
+}
+
+// Has satisfies the validator.BlockGetter interface
+func (b *FaultyBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	drop, _, err := b.inject(c.Bytes())
+	if err != nil || drop {
+		return false, err
+	}
+	return b.bs.Has(ctx, c)
+}
@@ -0,0 +1,245 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package validatortest builds state fixtures and publishes them to the backends of the validator, for use in tests
+package validatortest
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// Account is an account of a fixture, with its storage and code
+type Account struct {
+	Address common.Address
+	Nonce   uint64
+	Balance *big.Int
+	Storage map[common.Hash]common.Hash // slot values; zero values are not stored
+	Code    []byte
+}
+
+// Node is a state or storage trie node, or a code blob, of a fixture
+type Node struct {
+	Codec uint64      // cid.EthStateTrie, cid.EthStorageTrie or cid.Raw
+	Hash  common.Hash // keccak256 of Data
+	Owner common.Hash // for storage nodes, the hashed address of the account; zero otherwise
+	Path  []byte      // for trie nodes, the path of the node in its trie, as nibbles; nil for code
+	Data  []byte
+}
+
+// CID returns the CID the node is stored under
+func (n Node) CID() cid.Cid {
+	c, _ := RawdataToCid(n.Codec, n.Data, multihash.KECCAK_256)
+	return c
+}
+
+// Fixture is a state trie with its storage tries and code, as the nodes a backend holds for it
+type Fixture struct {
+	StateRoot   common.Hash
+	BlockNumber uint64
+	Accounts    []Account
+	Nodes       []Node
+}
+
+// NewFixture builds the state trie of the accounts, with their storage tries and code
+func NewFixture(accounts ...Account) (*Fixture, error) {
+	f := &Fixture{Accounts: accounts}
+	write := func(codec uint64) trie.NodeWriteFunc {
+		return func(owner common.Hash, path []byte, hash common.Hash, blob []byte) {
+			f.Nodes = append(f.Nodes, Node{
+				Codec: codec,
+				Hash:  hash,
+				Owner: owner,
+				Path:  common.CopyBytes(path),
+				Data:  common.CopyBytes(blob),
+			})
+		}
+	}
+
+	leaves := make(map[common.Hash][]byte, len(accounts))
+	code := make(map[common.Hash]bool)
+	for _, account := range accounts {
+		owner := crypto.Keccak256Hash(account.Address.Bytes())
+		if _, ok := leaves[owner]; ok {
+			return nil, fmt.Errorf("duplicate account %s", account.Address)
+		}
+		storageRoot, err := buildTrie(storageLeaves(account.Storage), trie.NewStackTrieWithOwner(write(cid.EthStorageTrie), owner))
+		if err != nil {
+			return nil, err
+		}
+		codeHash := types.EmptyCodeHash
+		if len(account.Code) > 0 {
+			codeHash = crypto.Keccak256Hash(account.Code)
+			if !code[codeHash] {
+				code[codeHash] = true
+				f.Nodes = append(f.Nodes, Node{Codec: cid.Raw, Hash: codeHash, Data: common.CopyBytes(account.Code)})
+			}
+		}
+		balance := account.Balance
+		if balance == nil {
+			balance = new(big.Int)
+		}
+		leaves[owner], err = rlp.EncodeToBytes(&types.StateAccount{
+			Nonce:    account.Nonce,
+			Balance:  balance,
+			Root:     storageRoot,
+			CodeHash: codeHash.Bytes(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	f.StateRoot, err = buildTrie(leaves, trie.NewStackTrie(write(cid.EthStateTrie)))
+	return f, err
+}
+
+// RandomAccounts returns n accounts with random balances, up to maxSlots storage slots each, and code for about half
+// of them
+func RandomAccounts(rng *rand.Rand, n, maxSlots int) []Account {
+	accounts := make([]Account, n)
+	for i := range accounts {
+		account := Account{Nonce: uint64(rng.Intn(100)), Balance: big.NewInt(rng.Int63())}
+		rng.Read(account.Address[:])
+		if slots := rng.Intn(maxSlots + 1); slots > 0 {
+			account.Storage = make(map[common.Hash]common.Hash, slots)
+			for j := 0; j < slots; j++ {
+				var slot, value common.Hash
+				rng.Read(slot[:])
+				rng.Read(value[32-1-rng.Intn(32):])
+				account.Storage[slot] = value
+			}
+		}
+		if rng.Intn(2) == 0 {
+			account.Code = make([]byte, 1+rng.Intn(256))
+			rng.Read(account.Code)
+		}
+		accounts[i] = account
+	}
+	return accounts
+}
+
+// RandomFixture builds the state of n random accounts, as returned by RandomAccounts
+func RandomFixture(rng *rand.Rand, n, maxSlots int) (*Fixture, error) {
+	return NewFixture(RandomAccounts(rng, n, maxSlots)...)
+}
+
+// StorageRoot returns the storage root of the account
+func (f *Fixture) StorageRoot(address common.Address) common.Hash {
+	owner := crypto.Keccak256Hash(address.Bytes())
+	for _, n := range f.Nodes {
+		if n.Codec == cid.EthStorageTrie && n.Owner == owner && len(n.Path) == 0 {
+			return n.Hash
+		}
+	}
+	return types.EmptyRootHash
+}
+
+// Delete removes the nodes matching the filter and returns them
+// Other copies of a removed node, such as the same node in another account's storage trie, are removed too, so that
+// none of them can be found once the fixture is published.
+func (f *Fixture) Delete(filter func(Node) bool) []Node {
+	var removed []Node
+	removedHashes := make(map[common.Hash]bool)
+	for _, n := range f.Nodes {
+		if filter(n) {
+			removed = append(removed, n)
+			removedHashes[n.Hash] = true
+		}
+	}
+	kept := f.Nodes[:0]
+	for _, n := range f.Nodes {
+		if !removedHashes[n.Hash] {
+			kept = append(kept, n)
+		}
+	}
+	f.Nodes = kept
+	return removed
+}
+
+// DeleteStateNode removes the state trie node at the path, returning false if there is none
+func (f *Fixture) DeleteStateNode(path []byte) (Node, bool) {
+	return first(f.Delete(func(n Node) bool {
+		return n.Codec == cid.EthStateTrie && bytes.Equal(n.Path, path)
+	}))
+}
+
+// DeleteStorageNode removes the node at the path of the account's storage trie, returning false if there is none
+func (f *Fixture) DeleteStorageNode(address common.Address, path []byte) (Node, bool) {
+	owner := crypto.Keccak256Hash(address.Bytes())
+	return first(f.Delete(func(n Node) bool {
+		return n.Codec == cid.EthStorageTrie && n.Owner == owner && bytes.Equal(n.Path, path)
+	}))
+}
+
+// DeleteCode removes the code of the account, returning false if it has none
+func (f *Fixture) DeleteCode(address common.Address) (Node, bool) {
+	for _, account := range f.Accounts {
+		if account.Address == address && len(account.Code) > 0 {
+			hash := crypto.Keccak256Hash(account.Code)
+			return first(f.Delete(func(n Node) bool { return n.Codec == cid.Raw && n.Hash == hash }))
+		}
+	}
+	return Node{}, false
+}
+
+// Returns the keccak256-hashed keys and RLP-encoded values of the storage trie of the slots
+func storageLeaves(storage map[common.Hash]common.Hash) map[common.Hash][]byte {
+	leaves := make(map[common.Hash][]byte, len(storage))
+	for slot, value := range storage {
+		if value == (common.Hash{}) {
+			continue
+		}
+		leaves[crypto.Keccak256Hash(slot.Bytes())], _ = rlp.EncodeToBytes(common.TrimLeftZeroes(value.Bytes()))
+	}
+	return leaves
+}
+
+// Inserts the leaves into the stack trie in key order and commits it, returning the root
+func buildTrie(leaves map[common.Hash][]byte, t *trie.StackTrie) (common.Hash, error) {
+	if len(leaves) == 0 {
+		return types.EmptyRootHash, nil
+	}
+	keys := make([]common.Hash, 0, len(leaves))
+	for key := range leaves {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	for _, key := range keys {
+		if err := t.TryUpdate(key.Bytes(), leaves[key]); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return t.Commit()
+}
+
+func first(nodes []Node) (Node, bool) {
+	if len(nodes) == 0 {
+		return Node{}, false
+	}
+	return nodes[0], true
+}
@@ -0,0 +1,106 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package validatortest
+
+import (
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/multiformats/go-multihash"
+
+	validator "github.com/cerc-io/eth-ipfs-state-validator/v5/pkg"
+)
+
+// NewMemoryStore returns an empty in-memory node store, to publish fixtures to and validate them from
+func NewMemoryStore() ethdb.KeyValueStore {
+	return memoryStore{memorydb.New()}
+}
+
+// memoryStore reports absent keys with validator.ErrNotFound, as memorydb's own error is not exported
+type memoryStore struct {
+	*memorydb.Database
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+func (s memoryStore) Get(key []byte) ([]byte, error) {
+	value, err := s.Database.Get(key)
+	if err != nil {
+		if has, _ := s.Database.Has(key); !has {
+			return nil, validator.ErrNotFound
+		}
+	}
+	return value, err
+}
+
+// NewMemoryValidator returns a new trie validator ontop of an in-memory node store
+func NewMemoryValidator(store ethdb.KeyValueStore, par validator.Params) *validator.Validator {
+	return validator.NewKeyValueValidator(store, "memory", par)
+}
+
+// Publish writes the nodes of the fixture to a store keyed by CID, such as a memory store or validator.NewPGIPFSWriter
+func (f *Fixture) Publish(store ethdb.KeyValueWriter) error {
+	for _, n := range f.Nodes {
+		if err := store.Put(n.CID().Bytes(), n.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishDB inserts the nodes of the fixture into ipld.blocks at its block number, in one transaction
+// The database is Postgres, or SQLite as opened by validator.OpenSQLite.
+func (f *Fixture) PublishDB(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, n := range f.Nodes {
+		if err := PublishRaw(tx, n.Codec, multihash.KECCAK_256, n.Data, f.BlockNumber); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PublishRaw derives a cid from raw bytes and provided codec and multihash type, and writes it to the db tx
+func PublishRaw(tx *sqlx.Tx, codec, mh uint64, raw []byte, blockNumber uint64) error {
+	c, err := RawdataToCid(codec, raw, mh)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO ipld.blocks (key, data, block_number) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+		c.String(), raw, blockNumber)
+	return err
+}
+
+// RawdataToCid takes the desired codec, multihash type, and a slice of bytes
+// and returns the proper cid of the object.
+func RawdataToCid(codec uint64, rawdata []byte, multiHash uint64) (cid.Cid, error) {
+	c, err := cid.Prefix{
+		Codec:    codec,
+		Version:  1,
+		MhType:   multiHash,
+		MhLength: -1,
+	}.Sum(rawdata)
+	if err != nil {
+		return cid.Cid{}, err
+	}
+	return c, nil
+}